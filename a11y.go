@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Rules of the testA11y test, by id.
+var A11Y_RULES = map[string]string{
+	"img-alt":        "images must have an alt attribute, empty for decorative images",
+	"input-label":    "form controls must have a label",
+	"link-name":      "links must have text",
+	"button-name":    "buttons must have text",
+	"heading-order":  "heading levels must not be skipped",
+	"document-title": "pages must have a title",
+	"html-lang":      "pages must declare their language",
+	"table-header":   "data tables must have header cells",
+}
+
+/*
+ * A Crestfile can turn a rule off for the paths matching
+ * a pattern:
+ *
+ *   suppress "/legacy/*" "table-header"
+ */
+type Suppression struct {
+	pattern PathPattern
+	rule    string
+}
+
+func (c *Context) suppress(pattern string, rule string) error {
+	if _, ok := A11Y_RULES[rule]; !ok {
+		return errors.New(fmt.Sprintf("%s %q", UNKNOWN_RULE, rule))
+	}
+	c.suppressions = append(c.suppressions, Suppression{pattern: newPathPattern(pattern), rule: rule})
+	return nil
+}
+
+func (c *Context) suppressed(path string, rule string) bool {
+	for _, suppression := range c.suppressions {
+		if suppression.rule == rule && suppression.pattern.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeElement writes n as a short start tag to point at it in a report.
+func describeElement(n *html.Node) string {
+	description := "<" + n.Data
+	for _, key := range []string{"id", "name", "type", "href", "src"} {
+		if value, ok := lookupAttr(n, key); ok {
+			description += fmt.Sprintf(" %s=%q", key, value)
+		}
+	}
+	return description + ">"
+}
+
+// accessibleName approximates the name assistive technology announces for n.
+func accessibleName(n *html.Node) string {
+	for _, key := range []string{"aria-label", "aria-labelledby", "title"} {
+		if value := strings.TrimSpace(getAttr(n, key)); len(value) > 0 {
+			return value
+		}
+	}
+	var name strings.Builder
+	for c := range n.Descendants() {
+		if c.Type == html.TextNode {
+			name.WriteString(c.Data)
+		} else if c.Type == html.ElementNode && c.Data == "img" {
+			name.WriteString(getAttr(c, "alt"))
+		} else if c.Type == html.ElementNode && c.Data == "svg" {
+			name.WriteString(getAttr(c, "aria-label"))
+		}
+	}
+	return strings.TrimSpace(name.String())
+}
+
+// hasLabel reports whether a form control is labelled, labels holding the ids named by <label for>.
+func hasLabel(n *html.Node, labels map[string]bool) bool {
+	for _, key := range []string{"aria-label", "aria-labelledby", "title"} {
+		if len(strings.TrimSpace(getAttr(n, key))) > 0 {
+			return true
+		}
+	}
+	if id := getAttr(n, "id"); len(id) > 0 && labels[id] {
+		return true
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && p.Data == "label" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Context) checkA11y(link Link, root *html.Node) []LinkFailure {
+	/*
+	 * Static accessibility checks on the parsed page. They
+	 * cannot replace testing with assistive technology, but
+	 * catch the mistakes that are visible in the markup.
+	 */
+	var failures []LinkFailure
+	report := func(rule string, format string, args ...any) {
+		if c.suppressed(link.path, rule) {
+			return
+		}
+		failures = append(failures, LinkFailure{link: link, check: "a11y", rule: rule, err: errors.New(fmt.Sprintf(format, args...))})
+	}
+
+	labels := make(map[string]bool)
+	for n := range root.Descendants() {
+		if n.Type == html.ElementNode && n.Data == "label" {
+			labels[getAttr(n, "for")] = true
+		}
+	}
+
+	title := false
+	lastHeading := 0
+	for n := range root.Descendants() {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		_, hasAlt := lookupAttr(n, "alt")
+		hidden := getAttr(n, "aria-hidden") == "true"
+		switch n.Data {
+		case "html":
+			if len(strings.TrimSpace(getAttr(n, "lang"))) == 0 {
+				report("html-lang", "<html> has no lang attribute")
+			}
+		case "title":
+			title = title || len(strings.TrimSpace(nodeText(n))) > 0
+		case "img", "area":
+			if !hasAlt && !hidden && getAttr(n, "role") != "presentation" {
+				report("img-alt", "%s has no alt attribute", describeElement(n))
+			}
+		case "input":
+			inputType := strings.ToLower(getAttr(n, "type"))
+			if inputType == "image" && !hasAlt {
+				report("img-alt", "%s has no alt attribute", describeElement(n))
+			} else if !slices.Contains([]string{"hidden", "submit", "reset", "button", "image"}, inputType) && !hasLabel(n, labels) {
+				report("input-label", "%s has no label", describeElement(n))
+			}
+		case "select", "textarea":
+			if !hasLabel(n, labels) {
+				report("input-label", "%s has no label", describeElement(n))
+			}
+		case "a":
+			if _, ok := lookupAttr(n, "href"); ok && !hidden && len(accessibleName(n)) == 0 {
+				report("link-name", "%s has no text", describeElement(n))
+			}
+		case "button":
+			if !hidden && len(accessibleName(n)) == 0 {
+				report("button-name", "%s has no text", describeElement(n))
+			}
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			level, _ := strconv.Atoi(n.Data[1:])
+			if lastHeading > 0 && level > lastHeading+1 {
+				report("heading-order", "<%s> follows <h%d>, skipping a level", n.Data, lastHeading)
+			}
+			lastHeading = level
+		case "table":
+			role := getAttr(n, "role")
+			if role != "presentation" && role != "none" && len(selectElements(n, "th")) == 0 {
+				report("table-header", "%s has no <th> cells", describeElement(n))
+			}
+		}
+	}
+	if !title {
+		report("document-title", "the page has no <title>")
+	}
+	return failures
+}
+
+func selectElements(n *html.Node, tag string) []*html.Node {
+	var nodes []*html.Node
+	for c := range n.Descendants() {
+		if c.Type == html.ElementNode && c.Data == tag {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Operators of the assert statement.
+var ASSERT_OPERATORS = []string{"contains", "excludes", "matches", "notMatches"}
+
+/*
+ * An assertion on every HTML page whose path matches scope:
+ *
+ *   assert "/docs/*" CONTENT contains "Made with crest"
+ *   assert "*" CONTENT notMatches "(?i)lorem ipsum"
+ *
+ * The subject, and the value, can be CONTENT (the source
+ * of the page) or CURRENT (its path), which are bound to
+ * the page being checked.
+ */
+type Assertion struct {
+	scope    PathPattern
+	subject  string
+	operator string
+	value    string
+	re       *regexp.Regexp
+}
+
+func newAssertion(scope string, subject string, operator string, value string) (Assertion, error) {
+	assertion := Assertion{scope: newPathPattern(scope), subject: subject, operator: operator, value: value}
+	if subject != "CONTENT" && subject != "CURRENT" {
+		return assertion, errors.New(INVALID_ASSERTION)
+	}
+	if !slices.Contains(ASSERT_OPERATORS, operator) {
+		return assertion, errors.New(INVALID_ASSERTION)
+	}
+	// Patterns bound to the page are compiled when it is checked.
+	if strings.HasSuffix(operator, "atches") && value != "CONTENT" && value != "CURRENT" {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return assertion, err
+		}
+		assertion.re = re
+	}
+	return assertion, nil
+}
+
+// problem describes a failed assertion, such as CONTENT does not contain "footer".
+func (a Assertion) problem() string {
+	value := a.value
+	if value != "CONTENT" && value != "CURRENT" {
+		value = fmt.Sprintf("%q", value)
+	}
+	verb := map[string]string{
+		"contains":   "does not contain",
+		"excludes":   "contains",
+		"matches":    "does not match",
+		"notMatches": "matches",
+	}[a.operator]
+	return fmt.Sprintf("%s %s %s", a.subject, verb, value)
+}
+
+// bind resolves the reserved variables to the page being checked.
+func (c *Context) bind(value string) string {
+	if value == "CURRENT" {
+		return c.CURRENT
+	}
+	if value == "CONTENT" {
+		return c.CONTENT
+	}
+	return value
+}
+
+func (c *Context) evaluate(assertion Assertion) (bool, error) {
+	subject := c.bind(assertion.subject)
+	value := c.bind(assertion.value)
+	re := assertion.re
+	if re == nil && strings.HasSuffix(assertion.operator, "atches") {
+		var err error
+		if re, err = regexp.Compile(regexp.QuoteMeta(value)); err != nil {
+			return false, err
+		}
+	}
+
+	switch assertion.operator {
+	case "contains":
+		return strings.Contains(subject, value), nil
+	case "excludes":
+		return !strings.Contains(subject, value), nil
+	case "matches":
+		return re.MatchString(subject), nil
+	default:
+		return !re.MatchString(subject), nil
+	}
+}
+
+func (c *Context) checkAssertions(result PageResult) error {
+	/*
+	 * CURRENT and CONTENT are only bound while the
+	 * assertions of a single page are evaluated.
+	 */
+	c.CURRENT = result.link.path
+	c.CONTENT = result.content
+	defer func() {
+		c.CURRENT = ""
+		c.CONTENT = ""
+	}()
+
+	for _, assertion := range c.assertions {
+		if !assertion.scope.Match(result.link.path) {
+			continue
+		}
+		ok, err := c.evaluate(assertion)
+		if err == nil && ok {
+			continue
+		}
+		if err == nil {
+			err = errors.New(assertion.problem())
+		}
+		c.printv(os.Stderr, fmt.Sprintf("Assertion failed on %s", result.link.path), fmt.Sprintf("Assertion failed on %s: %s", result.link.path, err))
+		c.failures = append(c.failures, LinkFailure{link: result.link, check: "assert", err: err})
+		if c.failFast {
+			return err
+		}
+	}
+	return nil
+}
+
+// Operators of the select statement.
+var SELECT_OPERATORS = []string{"exists", "count", "attr", "text"}
+
+/*
+ * An assertion on the elements matching a CSS selector,
+ * on every HTML page whose path matches scope:
+ *
+ *   select "/blog/*" "h1" count 1
+ *   select "/blog/*" "article time[datetime]" exists true
+ *   select "*" "html" attr "lang=en"
+ *   select "/blog/*" "h1" text "^[A-Z]"
+ */
+type Selection struct {
+	scope    PathPattern
+	selector string
+	parsed   Selector
+	operator string
+	value    string
+	count    int
+	re       *regexp.Regexp
+}
+
+func newSelection(scope string, selector string, operator string, value string) (Selection, error) {
+	selection := Selection{scope: newPathPattern(scope), selector: selector, operator: operator, value: value}
+	parsed, err := parseSelector(selector)
+	if err != nil {
+		return selection, err
+	}
+	selection.parsed = parsed
+
+	switch operator {
+	case "exists":
+		if value != "true" && value != "false" {
+			return selection, errors.New(INVALID_SELECTION)
+		}
+	case "count":
+		if selection.count, err = strconv.Atoi(value); err != nil || selection.count < 0 {
+			return selection, errors.New(INVALID_SELECTION)
+		}
+	case "attr":
+		if name, _, _ := strings.Cut(value, "="); len(name) == 0 {
+			return selection, errors.New(INVALID_SELECTION)
+		}
+	case "text":
+		if selection.re, err = regexp.Compile(value); err != nil {
+			return selection, err
+		}
+	default:
+		return selection, errors.New(INVALID_SELECTION)
+	}
+	return selection, nil
+}
+
+// check returns what is wrong with the elements of a page matching the selection, if anything.
+func (s Selection) check(n *html.Node) []string {
+	nodes := s.parsed.QueryAll(n)
+	if s.operator == "exists" {
+		if s.value == "true" && len(nodes) == 0 {
+			return []string{fmt.Sprintf("no element matches %q", s.selector)}
+		}
+		if s.value == "false" && len(nodes) > 0 {
+			return []string{fmt.Sprintf("%d elements match %q", len(nodes), s.selector)}
+		}
+		return nil
+	}
+	if s.operator == "count" {
+		if len(nodes) != s.count {
+			return []string{fmt.Sprintf("expected %d elements matching %q, found %d", s.count, s.selector, len(nodes))}
+		}
+		return nil
+	}
+	if len(nodes) == 0 {
+		return []string{fmt.Sprintf("no element matches %q", s.selector)}
+	}
+
+	var problems []string
+	name, expected, hasValue := strings.Cut(s.value, "=")
+	for _, node := range nodes {
+		if s.operator == "attr" {
+			value, ok := lookupAttr(node, name)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("<%s> matching %q has no %s attribute", node.Data, s.selector, name))
+			} else if hasValue && value != expected {
+				problems = append(problems, fmt.Sprintf("<%s> matching %q has %s=%q, expected %q", node.Data, s.selector, name, value, expected))
+			}
+		} else if text := nodeText(node); !s.re.MatchString(text) {
+			problems = append(problems, fmt.Sprintf("text %q of <%s> matching %q does not match %q", text, node.Data, s.selector, s.value))
+		}
+	}
+	return problems
+}
+
+// checkSelections runs in the crawl workers, so it only reads from the context.
+func (c *Context) checkSelections(link Link, n *html.Node) []LinkFailure {
+	var failures []LinkFailure
+	for _, selection := range c.selections {
+		if !selection.scope.Match(link.path) {
+			continue
+		}
+		for _, problem := range selection.check(n) {
+			failures = append(failures, LinkFailure{link: link, check: "select", err: errors.New(problem)})
+		}
+	}
+	return failures
+}
+
+func (c *Context) recordProblems(result PageResult) error {
+	/*
+	 * Page checks run while a page is fetched and parsed.
+	 * Their problems are recorded here, in discovery order.
+	 */
+	for _, problem := range result.problems {
+		c.printv(os.Stderr, fmt.Sprintf("%s check failed on %s", problem.check, result.link.path), fmt.Sprintf("%s check failed on %s: %s", problem.check, result.link.path, problem.err))
+		c.failures = append(c.failures, problem)
+		if c.failFast {
+			return problem.err
+		}
+	}
+	return nil
+}
@@ -7,17 +7,63 @@ import (
 )
 
 var TOKS map[string]string = map[string]string{
-	"=":            "ASSIGNMENT",
-	"true":         "BOOL",
-	"false":        "BOOL",
-	"exclude":      "SET",
-	"verbose":      "SET",
-	"quiet":        "SET",
-	"followRobots": "SET",
-	"type":         "SET",
-	"depth":        "SET",
-	"url":          "SET",
-	"testHTTP":     "TEST_TYPE",
+	"=":                 "ASSIGNMENT",
+	"true":              "BOOL",
+	"false":             "BOOL",
+	"exclude":           "SET",
+	"verbose":           "SET",
+	"quiet":             "SET",
+	"followRobots":      "SET",
+	"type":              "SET",
+	"depth":             "SET",
+	"concurrency":       "SET",
+	"failFast":          "SET",
+	"assets":            "SET",
+	"extract":           "SET",
+	"checkFragments":    "SET",
+	"sitemap":           "SET",
+	"orphans":           "SET",
+	"url":               "SET",
+	"root":              "SET",
+	"serve":             "SET",
+	"report":            "SET",
+	"robotsUnreachable": "SET",
+	"rate":              "SET",
+	"userAgent":         "SET",
+	"burst":             "SET",
+	"maxRedirects":      "SET",
+	"titleLength":       "SET",
+	"descriptionLength": "SET",
+	"allowStatus":       "SET",
+	"expectMissing":     "SET",
+	"redirect":          "STATEMENT",
+	"expect":            "STATEMENT",
+	"assert":            "STATEMENT",
+	"select":            "STATEMENT",
+	"suppress":          "STATEMENT",
+	"testHTTP":          "TEST_TYPE",
+	"testRobots":        "TEST_TYPE",
+	"testHTML":          "TEST_TYPE",
+	"testA11y":          "TEST_TYPE",
+	"testSEO":           "TEST_TYPE",
+}
+
+/*
+ * Statements take a fixed number of operands instead of
+ * the single value of a SET keyword:
+ *
+ *   redirect 301 warn
+ *   expect "/gone" status 410
+ *   assert "/docs/*" CONTENT contains "Made with crest"
+ *   select "/blog/*" "h1" count 1
+ *   suppress "/legacy/*" "table-header"
+ */
+var STATEMENT_OPERANDS map[string]int = map[string]int{
+	"redirect": 2,
+	"expect":   3,
+	"assert":   4,
+	"select":   4,
+	"suppress": 2,
 }
 
 /*
@@ -75,6 +121,7 @@ type State struct {
 	variable map[string]string
 
 	instructionSet []string
+	statements     [][]string
 
 	offset int
 	row    int
@@ -153,9 +200,16 @@ func (s *State) Parser() error {
 	tokens := s.lexNodes
 	var parserNodes []ParserNode
 
+	skip := 0
 	for i, c := range tokens {
 		var node ParserNode
 
+		// Operands of a statement are never parsed on their own.
+		if skip > 0 {
+			skip--
+			continue
+		}
+
 		if c.tok_type == "ASSIGNMENT" {
 			node.operation = "Assignment"
 			if i > 0 && i < len(tokens)-1 {
@@ -168,6 +222,18 @@ func (s *State) Parser() error {
 			parserNodes = append(parserNodes, node)
 			node.Clear()
 		}
+		if c.tok_type == "STATEMENT" {
+			node.operation = "Statement"
+			n := STATEMENT_OPERANDS[c.tok_raw]
+			if i+n < len(tokens) {
+				node.operands = tokens[i : i+n+1]
+			} else {
+				return s.parseError(fmt.Sprintf("%s takes %d operands", c.tok_raw, n), node.operation)
+			}
+			parserNodes = append(parserNodes, node)
+			node.Clear()
+			skip = n
+		}
 		if c.tok_type == "SET" {
 			node.operation = "Set"
 			if i < len(tokens)-1 {
@@ -229,23 +295,40 @@ func (s *State) Compiler() error {
 			if nameToken.tok_type != "" {
 				s.compileError("Invalid type for set name", c.operation)
 			}
-			if valueToken.tok_type == "VARIABLE" {
-				variableValue := ""
-				rawVariable := valueToken.tok_raw[1 : len(valueToken.tok_raw)-1]
-				variableValue = s.variable[rawVariable]
-				if len(variableValue) <= 0 {
-					return s.compileError("Variable not found", c.operation)
-				}
-				value = variableValue
-			} else if valueToken.tok_type == "STRING" {
-				value = valueToken.tok_raw[1 : len(valueToken.tok_raw)-1]
-			} else {
-				value = valueToken.tok_raw
+			value, err := s.operandValue(valueToken, c.operation)
+			if err != nil {
+				return err
 			}
 			name = nameToken.tok_raw
 			s.instructionSet = append(s.instructionSet, name)
 			s.instructionSet = append(s.instructionSet, value)
 		}
+		if c.operation == "Statement" {
+			statement := []string{c.operands[0].tok_raw}
+			for _, operand := range c.operands[1:] {
+				value, err := s.operandValue(operand, c.operation)
+				if err != nil {
+					return err
+				}
+				statement = append(statement, value)
+			}
+			s.statements = append(s.statements, statement)
+		}
 	}
 	return nil
 }
+
+// operandValue substitutes variables and strips the quotes of strings.
+func (s *State) operandValue(token LexNode, operation string) (string, error) {
+	if token.tok_type == "VARIABLE" {
+		rawVariable := token.tok_raw[1 : len(token.tok_raw)-1]
+		variableValue := s.variable[rawVariable]
+		if len(variableValue) <= 0 {
+			return "", s.compileError("Variable not found", operation)
+		}
+		return variableValue, nil
+	} else if token.tok_type == "STRING" {
+		return token.tok_raw[1 : len(token.tok_raw)-1], nil
+	}
+	return token.tok_raw, nil
+}
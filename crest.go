@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"golang.org/x/net/html"
 )
@@ -18,36 +24,207 @@ import (
 const (
 	INVALID_AMOUNT_COMMANDLINE_ARGUMENTS = "Invalid amount of commandline arguments."
 	FLAGS_PLACEMENT                      = "Flag or URL placement bad. Please insure URL is at the end of your command. All flags must be somewhere in between command-name (crest) and the argument (url)."
+	EMPTY_FLAG_VALUE                     = "Missing value for"
 	NON_LOCALHOST_CRAWL                  = "You are trying to crawl a site that is not on your localhost. This action is forbidden. \nDon't fret! If your site has hrefs which redirect to other sites, they will be ignored and won't throw errors. However, crawling an entirely different domain is entirely unsupported."
 	SCHEME_REQUIRED                      = "All URL's must contain their scheme (http, https, etc...)"
 	STATUS_ERROR                         = "STATUS ERROR!"
-	INVALID_TEST                         = "Testing type is either invalid or unspecified: specify with '--test-http'/'-t' flag"
+	INVALID_TEST                         = "Testing type is either invalid or unspecified: specify with '--test-http'/'-t', '--test-html', '--test-a11y' or '--test-seo' flag"
 	UNRECOGNIZED_COMMAND                 = "Command unrecognized. Please look at the documentation. If you believe there's a problem with crest, feel free to create an issue. Just make sure to read the readme.md file and the issues tab first to see if your issue is already being worked on."
 	INCLUDE_PORT                         = "As of now, your URL must include a port."
+	INVALID_CONCURRENCY                  = "Concurrency must be a positive number of workers."
+	CHECKS_FAILED                        = "Crest finished with failed checks:"
+	ROOT_REQUIRED                        = "A directory to crawl is required: crest crawl --dir ./public"
+	SERVE_ROOT_REQUIRED                  = "A directory to serve is required: crest serve ./public"
+	ROOT_NOT_DIRECTORY                   = "The root you are trying to crawl is not a directory."
+	INVALID_REDIRECT_POLICY              = "Redirect policies must be written as --redirect CODE=POLICY, for example --redirect 301=warn, or as redirect CODE POLICY in a Crestfile, for example redirect 301 warn. Codes: 301, 302, 303, 307, 308. Policies: pass, warn, fail."
+	INVALID_STATUS                       = "Status codes must be numbers between 100 and 599."
+	INVALID_ASSERTION                    = "Assertions must be written as assert SCOPE CONTENT|CURRENT contains|excludes|matches|notMatches VALUE."
+	INVALID_SELECTOR                     = "Unsupported or invalid CSS selector"
+	INVALID_SELECTION                    = "Selections must be written as select SCOPE SELECTOR exists true|false, count N, attr NAME[=VALUE] or text PATTERN."
+	INVALID_LENGTH                       = "Length limits must be written as MIN-MAX, for example 10-60:"
+	UNKNOWN_RULE                         = "Unknown rule"
+	INVALID_EXPECT                       = "Expectations must be written as expect PATH status CODE, for example expect \"/gone\" status 410."
+	INVALID_EXPECT_PATH                  = "Expected paths must start with / or *:"
+	INVALID_MAX_REDIRECTS                = "The maximum number of redirects must be a positive number."
+	REDIRECT_LOOP                        = "Redirect loop:"
+	REDIRECT_TOO_LONG                    = "Redirect chain longer than"
+	REDIRECT_OFFSITE                     = "Redirect leaves localhost:"
+	REDIRECT_DOWNGRADE                   = "Redirect downgrades https to http:"
+	ORPHANS_ROOT_REQUIRED                = "Orphan files can only be found in a build directory: use --orphans with crest crawl --dir or crest serve."
+	INVALID_REPORT                       = "Reports must be written as format=path, for example json=report.json. Supported formats: json, junit."
+	INVALID_ROBOTS_UNREACHABLE           = "What to do when robots.txt is unreachable must be either allow or disallow."
+	INVALID_RATE                         = "The rate must be a positive number of requests per second."
+	INVALID_BURST                        = "The burst must be a positive number of requests."
+	INVALID_EXTRACTOR                    = "Extractors must be written as element:attribute, for example img:data-src."
+	INVALID_SITEMAP                      = "A sitemap must be a <urlset> or a <sitemapindex>, got"
+	INVALID_SITEMAP_URL                  = "Sitemap URLs must be absolute, got"
 )
 
+const (
+	DEFAULT_DEPTH       = 20
+	DEFAULT_CONCURRENCY = 8
+)
+
+// Test types a Crestfile can ask for with the type keyword.
+var TEST_TYPES = []string{"testHTTP", "testRobots", "testHTML", "testA11y", "testSEO"}
+
 type Context struct {
-	quiet        bool
-	verbose      bool
-	followRobots bool
-	exclude      []string
-	depth        int
+	quiet             bool
+	verbose           bool
+	followRobots      bool
+	exclude           []string
+	depth             int
+	concurrency       int
+	root              string
+	client            *http.Client
+	failFast          bool
+	skipAssets        bool
+	checkFragments    bool
+	sitemap           bool
+	orphans           bool
+	extractors        []LinkExtractor
+	reports           []string
+	robotsUnreachable string
+	robots            *RobotsCache
+	userAgent         string
+	rate              float64
+	burst             int
+	limiter           *HostLimiter
+	redirectPolicies  map[int]string
+	allowStatus       []int
+	expectations      []Expectation
+	maxRedirects      int
+	titleLength       LengthRange
+	descriptionLength LengthRange
+	host              string
+	tests             []string
+	visited           *VisitedSet
+	pages             []PageResult
+	referrers         map[string][]string
+	failures          []LinkFailure
+	warnings          []LinkFailure
+	anchors           map[string]map[string]bool
+	fragments         []Link
+	sitemapPages      map[string]string
+
+	assertions   []Assertion
+	selections   []Selection
+	suppressions []Suppression
+	CURRENT      string
+	CONTENT      string
+}
+
+/*
+ * Set of links that have already been queued during a crawl.
+ * Safe to share between the crawl workers.
+ */
+type VisitedSet struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func NewVisitedSet() *VisitedSet {
+	return &VisitedSet{seen: make(map[string]bool)}
+}
+
+// Add marks link as visited and reports whether it was not visited before.
+func (v *VisitedSet) Add(link string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.seen[link] {
+		return false
+	}
+	v.seen[link] = true
+	return true
+}
+
+func (v *VisitedSet) Has(link string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.seen[link]
+}
+
+// Links returns every visited link in sorted order.
+func (v *VisitedSet) Links() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	links := make([]string, 0, len(v.seen))
+	for link := range v.seen {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	return links
+}
+
+type Link struct {
+	path     string
+	fragment string
+	referrer string
+	text     string
+	asset    bool
+}
+
+type PageResult struct {
+	link        Link
+	url         string
+	redirects   []Redirect
+	depth       int
+	status      int
+	contentType string
+	elapsed     time.Duration
+	links       []Link
+	anchors     map[string]bool
+	content     string
+	seo         *SeoMetadata
+	problems    []LinkFailure
+	err         error
+}
+
+// failure describes why fetching the page failed.
+func (r *PageResult) failure() LinkFailure {
+	check := "http"
+	var redirectErr *RedirectError
+	if errors.As(r.err, &redirectErr) {
+		check = "redirect"
+	}
+	return LinkFailure{link: r.link, check: check, status: r.status, err: r.err}
+}
+
+type LinkFailure struct {
+	link   Link
+	check  string
+	status int
+	line   int
+	col    int
+	rule   string
+	err    error
+}
 
-	// CURRENT string
-	// CONTENT string
+func (f *LinkFailure) message() string {
+	var statusErr *StatusError
+	if errors.As(f.err, &statusErr) && len(statusErr.expected) > 0 {
+		return fmt.Sprintf("%s, expected %s", http.StatusText(f.status), formatStatuses(statusErr.expected))
+	}
+	if f.check == "http" && f.status != 0 {
+		return http.StatusText(f.status)
+	}
+	if len(f.rule) > 0 {
+		return fmt.Sprintf("%s: %s", f.rule, f.err)
+	}
+	return f.err.Error()
 }
 
-type RobotPolicy struct {
-	agent    string
-	allow    []string
-	disallow []string
+type StatusError struct {
+	url      string
+	status   int
+	expected []int
 }
 
-func (p *RobotPolicy) Empty() bool {
-	if len(p.allow) == 0 && len(p.disallow) == 0 && len(p.agent) == 0 {
-		return true
+func (e *StatusError) Error() string {
+	if len(e.expected) > 0 {
+		return fmt.Sprintf("%s in %s | STATUS: %d, EXPECTED: %s", STATUS_ERROR, e.url, e.status, formatStatuses(e.expected))
 	}
-	return false
+	return fmt.Sprintf("%s in %s | STATUS: %d", STATUS_ERROR, e.url, e.status)
 }
 
 func (c *Context) computeExcludedLinks(links []string) []string {
@@ -94,6 +271,18 @@ func (c *Context) printv(stream io.Writer, out string, longOut string) {
 	}
 }
 
+// warnv prints problems that do not fail the run, unless in quiet mode.
+func (c *Context) warnv(out string, longOut string) {
+	reset := "\033[0m"
+	color := "\033[33m WARNING: "
+
+	if c.verbose && len(longOut) > 0 {
+		fmt.Fprintln(os.Stderr, color+longOut+reset)
+	} else if !c.quiet {
+		fmt.Fprintln(os.Stderr, color+out+reset)
+	}
+}
+
 func splitUrl(raw string) map[string]string {
 	urlStructure := make(map[string]string)
 
@@ -119,227 +308,404 @@ func splitUrl(raw string) map[string]string {
 	return urlStructure
 }
 
-func RobotParser(url string, ctx *Context) ([]RobotPolicy, error) {
-	var robotPolicies []RobotPolicy
-	robot_path := url + "/robots.txt"
-
-	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodGet, robot_path, nil)
-	if err != nil {
-		return nil, err
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	if res.StatusCode != http.StatusOK {
-		return nil, errors.New("status err")
+func (c *Context) httpClient() *http.Client {
+	if c.client == nil {
+		return &http.Client{}
 	}
+	return c.client
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+func Page(host string, path string, ctx *Context) (*http.Response, error) {
+	res, _, err := fetchWithRedirects(host, path, ctx)
+	return res, err
+}
+
+func fetchPage(host string, link Link, depth int, ctx *Context) PageResult {
+	result := PageResult{link: link, depth: depth, status: http.StatusOK}
+	start := time.Now()
+	r, redirects, err := fetchWithRedirects(host, link.path, ctx)
+	result.elapsed = time.Since(start)
+	result.redirects = redirects
 	if err != nil {
-		return nil, err
+		var statusErr *StatusError
+		var redirectErr *RedirectError
+		result.status = 0
+		if errors.As(err, &statusErr) {
+			result.status = statusErr.status
+		} else if errors.As(err, &redirectErr) {
+			result.status = redirectErr.chain[len(redirectErr.chain)-1].status
+		}
+		result.err = err
+		return result
 	}
-	robotContents := strings.Split(string(body), "\n")
-
-	var policy RobotPolicy
-	for i := range robotContents {
+	defer r.Body.Close()
+	result.url = r.Request.URL.String()
+	result.status = r.StatusCode
 
-		if len(robotContents[i]) > 0 {
-			comment := string(robotContents[i][0])
-			if comment == "#" || comment == " " {
-				continue
-			}
+	contentType := r.Header.Get("Content-Type")
+	result.contentType = contentType
+	if r.StatusCode != http.StatusOK {
+		// Expected and allowed error pages are not crawled.
+		io.Copy(io.Discard, r.Body)
+	} else if isCss(contentType) && !ctx.skipAssets {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			result.err = err
+			return result
 		}
-
-		if len(robotContents[i]) >= 8 {
-			disallow := robotContents[i][0:8]
-			if disallow == "Disallow" {
-				content := strings.TrimSpace(robotContents[i][9:])
-				policy.disallow = append(policy.disallow, content)
+		result.links = getCssLinks(string(body), r.Request.URL, r.Request.URL)
+	} else if link.asset || !isHtml(contentType) {
+		// Assets and non-HTML documents are only checked, never crawled.
+		io.Copy(io.Discard, r.Body)
+	} else {
+		var body io.Reader = r.Body
+		var source []byte
+		if len(ctx.assertions) > 0 || ctx.hasTest("testHTML") {
+			source, err = io.ReadAll(r.Body)
+			if err != nil {
+				result.err = err
+				return result
 			}
+			body = bytes.NewReader(source)
 		}
-
-		if len(robotContents[i]) >= 5 {
-			allow := robotContents[i][0:5]
-			if allow == "Allow" {
-				content := strings.TrimSpace(robotContents[i][6:])
-				policy.allow = append(policy.allow, content)
-			}
+		if len(ctx.assertions) > 0 {
+			result.content = string(source)
 		}
-
-		if len(robotContents[i]) >= 10 {
-			agent := robotContents[i][0:10]
-			if agent == "User-agent" {
-				if policy.Empty() {
-					content := strings.TrimSpace(robotContents[i][11:])
-					policy.agent = content
-				} else {
-					robotPolicies = append(robotPolicies, policy)
-					policy = RobotPolicy{}
-					content := strings.TrimSpace(robotContents[i][11:])
-					policy.agent = content
-				}
-			}
+		node, err := html.Parse(body)
+		if err != nil {
+			result.err = err
+			return result
 		}
-		if i == len(robotContents)-1 {
-			robotPolicies = append(robotPolicies, policy)
+		result.links = getPageLinksTask(node, r.Request.URL, ctx.linkExtractors())
+		if !ctx.skipAssets {
+			result.links = append(result.links, getInlineCssLinks(node, r.Request.URL)...)
 		}
+		if ctx.checkFragments {
+			result.anchors = getPageAnchors(node)
+		}
+		result.problems = append(result.problems, ctx.checkSelections(link, node)...)
+		if ctx.hasTest("testHTML") {
+			result.problems = append(result.problems, checkHtml(link, source)...)
+		}
+		if ctx.hasTest("testA11y") {
+			result.problems = append(result.problems, ctx.checkA11y(link, node)...)
+		}
+		if ctx.hasTest("testSEO") {
+			metadata, problems := ctx.checkSeo(link, node, r.Request.URL, r.Header)
+			result.seo = &metadata
+			result.problems = append(result.problems, problems...)
+		}
+	}
 
+	for i := range result.links {
+		result.links[i].referrer = link.path
 	}
-	return robotPolicies, nil
+	return result
 }
 
-func GetAllowedRobots(url string, links []string, ctx *Context) ([]string, error) {
+func crawlLevel(host string, links []Link, depth int, ctx *Context) []PageResult {
 	/*
-	 * Compare the policy provided by robots.txt file
-	 * in order to determine which paths to permit.
-	 * The final list of allowed paths is called the
-	 * delta.
+	 * Fetch every link of a single depth level using at most
+	 * ctx.concurrency workers. Results are stored at the index
+	 * of their link so the caller sees them in discovery order
+	 * no matter which worker finished first.
 	 */
-	policies, err := RobotParser(url, ctx)
-	ctx.printv(os.Stdout, "Generated robot policies", "")
-	if err != nil {
-		return nil, err
+	results := make([]PageResult, len(links))
+	workers := ctx.concurrency
+	if workers <= 0 {
+		workers = DEFAULT_CONCURRENCY
 	}
-	var delta []string
-
-	disallows := []string{}
-	for p := range policies {
-		policy := policies[p]
-		if policy.agent == "Crestbot" || policy.agent == "*" {
-			disallows = append(disallows, policy.disallow...)
-		}
+	if workers > len(links) {
+		workers = len(links)
 	}
 
-	for l := range links {
-		linkDisallow := false
-		link := links[l]
-		for d := range disallows {
-			if link == disallows[d] {
-				linkDisallow = true
-				break
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = fetchPage(host, links[i], depth, ctx)
 			}
+		}()
+	}
+	for i := range links {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func printFailureSummary(failures []LinkFailure) {
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tURL\tLINKED FROM\tANCHOR TEXT\tPROBLEM")
+	for _, failure := range failures {
+		status := strconv.Itoa(failure.status)
+		if failure.status == 0 && failure.check == "http" {
+			status = "ERR"
+		} else if failure.status == 0 {
+			status = "-"
+		}
+		link := failureUrl(failure)
+		if failure.line > 0 {
+			link += ":" + strconv.Itoa(failure.line)
 		}
-		if !linkDisallow {
-			delta = append(delta, link)
+		if failure.col > 0 {
+			link += ":" + strconv.Itoa(failure.col)
 		}
+		referrer := failure.link.referrer
+		if len(referrer) == 0 {
+			referrer = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%q\t%s\n", failure.check, status, link, referrer, failure.link.text, failure.message())
 	}
-
-	return delta, nil
+	w.Flush()
 }
 
-func Page(host string, path string, ctx *Context) (*http.Response, error) {
-	url := host + path
-	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+func RecursiveLinkCheck(host string, path string, ctx *Context) error {
+	/*
+	 * Crawl the URLs declared in anchor tags
+	 * breadth first to a depth not exceeding ctx.depth.
+	 * Each level is fetched by a bounded worker pool and
+	 * the results are handled in discovery order, so the
+	 * output is the same between runs. This is the meat
+	 * and potatoes of the --test-http flag and by extension
+	 * the entirety of crest.
+	 *
+	 * Broken links are collected and reported together
+	 * once the crawl is done, unless ctx.failFast is set
+	 * in which case the first one ends the crawl.
+	 */
+	if len(path) == 0 {
+		path = "/"
 	}
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if ctx.visited == nil {
+		ctx.visited = NewVisitedSet()
+	}
+	if ctx.referrers == nil {
+		ctx.referrers = make(map[string][]string)
 	}
-	if res.StatusCode != http.StatusOK {
-		ctx.printv(os.Stderr, "Recieved status error", "")
-		return nil, errors.New(fmt.Sprintf("%s in %s | STATUS: %d", STATUS_ERROR, url, res.StatusCode))
+	ctx.host = host
+	ctx.limiter = NewHostLimiter(ctx.rate, ctx.burst)
+	if ctx.followRobots {
+		ctx.robots = NewRobotsCache()
+		ctx.robots.Get(host, ctx)
+	}
+	maxDepth := ctx.depth
+	if maxDepth <= 0 {
+		maxDepth = DEFAULT_DEPTH
 	}
 
-	return res, nil
-}
+	ctx.visited.Add(path)
+	links := []Link{{path: path}}
+	if ctx.sitemap {
+		links = append(links, ctx.queueLinks(host, ctx.readSitemaps(host))...)
+	} else if ctx.hasTest("testSEO") {
+		// Only to find noindex pages listed in the sitemap, the crawl is not seeded.
+		ctx.readSitemaps(host)
+	}
+	for depth := 0; len(links) > 0; depth++ {
+		results := crawlLevel(host, links, depth, ctx)
 
-func getPageLinksTask(n *html.Node) []string {
-	var pageLinks []string
+		newLinks := []Link{}
+		for i, result := range results {
+			ctx.pages = append(ctx.pages, result)
+			if result.err != nil {
+				if ctx.failFast {
+					ctx.printv(os.Stderr, fmt.Sprintf("Quitted at %s which is link %d of %d total links at link recursion depth %d", result.link.path, i, len(links), depth), "")
+					ctx.failures = append(ctx.failures, result.failure())
+					return result.err
+				}
+				ctx.printv(os.Stderr, fmt.Sprintf("Broken link %s", result.link.path), result.err.Error())
+				ctx.failures = append(ctx.failures, result.failure())
+				continue
+			}
+			if err := ctx.checkRedirectPolicy(result); err != nil {
+				return err
+			}
+			if len(ctx.assertions) > 0 && result.status == http.StatusOK && !result.link.asset && isHtml(result.contentType) {
+				if err := ctx.checkAssertions(result); err != nil {
+					return err
+				}
+				// The source is not needed anymore, do not keep it for the whole crawl.
+				ctx.pages[len(ctx.pages)-1].content = ""
+			}
+			if err := ctx.recordProblems(result); err != nil {
+				return err
+			}
+			ctx.printv(os.Stdout, "Response checked", fmt.Sprintf("Response for %s checked at depth %d", result.link.path, depth))
+			if ctx.checkFragments {
+				ctx.recordFragments(result)
+			}
 
-	for c := range n.Descendants() {
-		var linkBuffer []string
-		if c.Type == html.ElementNode && c.Data == "a" {
-			for _, attr := range c.Attr {
-				if attr.Key == "href" {
-					link := attr.Val
-					urlStructure := splitUrl(link)
-					if urlStructure["hostname"] == "" && urlStructure["scheme"] == "" && urlStructure["fragment"] == "" {
-						linkBuffer = append(linkBuffer, link)
-					}
+			// Pages at the maximum depth still get their assets checked.
+			pageLinks := []Link{}
+			for _, link := range result.links {
+				if link.asset || depth < maxDepth {
+					pageLinks = append(pageLinks, link)
 				}
 			}
+
+			newLinks = append(newLinks, ctx.queueLinks(host, pageLinks)...)
+		}
+		links = newLinks
+	}
+
+	// Only pages the crawl discovered have to be in the sitemap.
+	if ctx.sitemap {
+		ctx.checkSitemapOrphans()
+	}
+	if err := ctx.checkExpectedPaths(host); err != nil {
+		return err
+	}
+	if ctx.checkFragments {
+		ctx.checkFragmentLinks()
+	}
+	if ctx.orphans {
+		if err := ctx.checkOrphanFiles(); err != nil {
+			return err
+		}
+	}
+	if ctx.hasTest("testSEO") {
+		if err := ctx.checkSeoPages(host); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queueLinks filters links by robots.txt and exclude and returns the ones not visited yet.
+func (c *Context) queueLinks(host string, links []Link) []Link {
+	paths := []string{}
+	for _, link := range links {
+		paths = append(paths, link.path)
+	}
+	if c.followRobots {
+		paths = GetAllowedRobots(host, paths, c)
+	}
+	allowed := make(map[string]bool)
+	for _, path := range c.computeExcludedLinks(paths) {
+		allowed[path] = true
+	}
+	queued := []Link{}
+	for _, link := range links {
+		if !allowed[link.path] {
+			continue
+		}
+		if !slices.Contains(c.referrers[link.path], link.referrer) {
+			c.referrers[link.path] = append(c.referrers[link.path], link.referrer)
+		}
+		if c.visited.Add(link.path) {
+			queued = append(queued, link)
 		}
-		pageLinks = append(pageLinks, linkBuffer...)
 	}
-	return pageLinks
+	return queued
 }
 
-func RecursiveLinkCheck(host string, path string, links []string, ctx *Context, depth int) error {
+func (c *Context) finish() error {
 	/*
-	 * Crawl non-fragment URLs declared in anchor tags
-	 * to a depth not exceeding 20 stack frames. This
-	 * is the meat and potatoes of the --test-http flag
-	 * and by extension the entirety of crest.
+	 * Write the reports and print every failure collected
+	 * by the tests that ran. Any failure fails the run,
+	 * warnings are only listed.
 	 */
-	url := host + path
-	if depth == 0 {
-		links = append(links, path)
+	if err := c.writeReports(); err != nil {
+		return err
 	}
 
-	linkLength := len(links)
-	newLinks := []string{}
-	for i := range linkLength {
-		r, err := Page(url, links[i], ctx)
-		if err != nil {
-			ctx.printv(os.Stderr, fmt.Sprintf("Quitted at %s which is link %d of %d total links at link recursion depth %d", links[i], i, len(links), depth), "")
+	if len(c.warnings) > 0 && !c.quiet {
+		fmt.Fprintf(os.Stderr, "%d warnings:\n", len(c.warnings))
+		printFailureSummary(c.warnings)
+	}
+
+	if len(c.failures) > 0 {
+		printFailureSummary(c.failures)
+		return errors.New(fmt.Sprintf("%s %d failures", CHECKS_FAILED, len(c.failures)))
+	}
+
+	return nil
+}
+
+func runTests(host string, path string, tests []string, ctx *Context) error {
+	if ctx.orphans && len(ctx.root) == 0 {
+		return errors.New(ORPHANS_ROOT_REQUIRED)
+	}
+	ctx.tests = tests
+	ctx.host = host
+	if slices.Contains(tests, "testRobots") {
+		if err := LintRobots(host, path, ctx); err != nil {
 			return err
 		}
-		ctx.printv(os.Stdout, "Response open", fmt.Sprintf("Response opened at depth %d", depth))
-		node, err := html.Parse(r.Body)
-		if err != nil {
-			ctx.printv(os.Stderr, "Problem getting nodes", "Problem getting HTML nodes from request")
+	}
+	if slices.ContainsFunc(tests, func(test string) bool { return test != "testRobots" }) {
+		if err := RecursiveLinkCheck(host, path, ctx); err != nil {
+			if reportErr := ctx.writeReports(); reportErr != nil {
+				return reportErr
+			}
 			return err
 		}
-		if ctx.followRobots {
-			accountForRobots, err := GetAllowedRobots(url, getPageLinksTask(node), ctx)
-			if err != nil {
-				return err
-			}
+		ctx.printv(os.Stdout, "Got links", "Recursive link check done")
+	}
+	return ctx.finish()
+}
 
-			tmp := ctx.computeExcludedLinks(accountForRobots)
+func (c *Context) hasTest(test string) bool {
+	return slices.Contains(c.tests, test)
+}
 
-			newLinks = append(newLinks, tmp...)
-		} else {
-			newLinks = append(newLinks, ctx.computeExcludedLinks(getPageLinksTask(node))...)
-		}
-		ctx.exclude = append(ctx.exclude, links[i])
-		r.Body.Close()
-		ctx.printv(os.Stdout, "Response closed", fmt.Sprintf("Response closed at depth %d", depth))
+func addTest(tests []string, test string) []string {
+	if slices.Contains(tests, test) {
+		return tests
 	}
+	return append(tests, test)
+}
 
-	if depth < ctx.depth {
-		return RecursiveLinkCheck(host, path, newLinks, ctx, depth+1)
+// flagValue returns the value following the flag at args[i], which may not be empty.
+func flagValue(args []string, i int, last int) (string, error) {
+	if i+1 >= last {
+		if last < len(args) {
+			return "", errors.New(FLAGS_PLACEMENT)
+		}
+		return "", errors.New(fmt.Sprintf("%s %s", EMPTY_FLAG_VALUE, args[i]))
 	}
-
-	return nil
+	if len(args[i+1]) == 0 {
+		return "", errors.New(fmt.Sprintf("%s %s", EMPTY_FLAG_VALUE, args[i]))
+	}
+	return args[i+1], nil
 }
 
-func Handle(args []string, ctx *Context) error {
+func parseFlags(args []string, positional bool, ctx *Context) ([]string, error) {
 	/*
-	 * Handle commandline stuff.
-	 * The code is very self explanitory.
-	 * Will probably refactor at some point
-	 * if crest's commandline interface becomes
-	 * expressive enough.
+	 * Parse the flags shared by every command and
+	 * return the requested test types, if any.
 	 */
-	var test string
+	var tests []string
 
-	if len(args) <= 1 {
-		return errors.New(UNRECOGNIZED_COMMAND)
+	/*
+	 * A positional argument, such as the URL to crawl,
+	 * comes last and is never read as a flag. Commands
+	 * without one take flags anywhere.
+	 */
+	last := len(args)
+	if positional {
+		last = len(args) - 1
+		if last < 1 || strings.HasPrefix(args[last], "-") {
+			return nil, errors.New(FLAGS_PLACEMENT)
+		}
 	}
 
-	for i := range args {
-		if string(args[i][0]) == "-" {
-			if i == len(args)-1 {
-				return errors.New(FLAGS_PLACEMENT)
-			}
-			for j := range args[i] {
-				c := string(args[i][j])
+	for i := 0; i < last; i++ {
+		/*
+		 * Flags taking a value skip over it, so the value is
+		 * never mistaken for a flag or an argument.
+		 */
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") {
+			for j := range arg {
+				c := string(arg[j])
 				if c == "v" {
 					ctx.verbose = true
 				}
@@ -350,58 +716,315 @@ func Handle(args []string, ctx *Context) error {
 					ctx.followRobots = true
 				}
 				if c == "t" {
-					test = "test-http"
+					tests = addTest(tests, "testHTTP")
 				}
 			}
 		}
-		if args[i] == "--verbose" {
-			if i == len(args)-1 {
-				return errors.New(FLAGS_PLACEMENT)
-			}
+		if arg == "--verbose" {
 			ctx.verbose = true
 		}
-		if args[i] == "--quiet" {
-			if i == len(args)-1 {
-				return errors.New(FLAGS_PLACEMENT)
-			}
+		if arg == "--quiet" {
 			ctx.quiet = true
 		}
-		if args[i] == "--follow-robots" {
-			if i == len(args)-1 {
-				return errors.New(FLAGS_PLACEMENT)
-			}
+		if arg == "--follow-robots" {
 			ctx.followRobots = true
 		}
-		if args[i] == "--test-http" {
-			if i == len(args)-1 {
-				return errors.New(FLAGS_PLACEMENT)
+		if arg == "--test-http" {
+			tests = addTest(tests, "testHTTP")
+		}
+		if arg == "--test-html" {
+			tests = addTest(tests, "testHTML")
+		}
+		if arg == "--test-a11y" {
+			tests = addTest(tests, "testA11y")
+		}
+		if arg == "--test-seo" {
+			tests = addTest(tests, "testSEO")
+		}
+		if arg == "--fail-fast" {
+			ctx.failFast = true
+		}
+		if arg == "--no-assets" {
+			ctx.skipAssets = true
+		}
+		if arg == "--check-fragments" {
+			ctx.checkFragments = true
+		}
+		if arg == "--orphans" {
+			ctx.orphans = true
+		}
+		if arg == "--sitemap" {
+			ctx.sitemap = true
+		}
+		if arg == "--concurrency" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			num, err := strconv.Atoi(value)
+			if err != nil || num <= 0 {
+				return nil, errors.New(INVALID_CONCURRENCY)
 			}
-			test = "test-http"
+			ctx.concurrency = num
 		}
-	}
-
-	if test == "test-http" {
-		url := args[len(args)-1]
-		urlData := splitUrl(url)
-		host := urlData["scheme"] + "://" + urlData["hostname"] + ":" + urlData["port"]
-		path := urlData["path"]
-		if urlData["scheme"] != "http" && urlData["scheme"] != "https" {
-			return errors.New(SCHEME_REQUIRED)
+		if arg == "--report" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			if _, _, err := parseReport(value); err != nil {
+				return nil, err
+			}
+			ctx.reports = append(ctx.reports, value)
 		}
-		if urlData["hostname"] != "localhost" && urlData["hostname"] != "127.0.0.1" {
-			return errors.New(NON_LOCALHOST_CRAWL)
+		if arg == "--allow-status" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			statuses, err := parseStatuses(value)
+			if err != nil {
+				return nil, err
+			}
+			ctx.allowStatus = append(ctx.allowStatus, statuses...)
 		}
-		if len(urlData["port"]) == 0 {
-			return errors.New(INCLUDE_PORT)
+		if arg == "--redirect" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			code, policy, _ := strings.Cut(value, "=")
+			if err := ctx.setRedirectPolicy(code, policy); err != nil {
+				return nil, err
+			}
 		}
-		if err := RecursiveLinkCheck(host, path, []string{}, ctx, 0); err != nil {
-			return err
+		if arg == "--max-redirects" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			num, err := strconv.Atoi(value)
+			if err != nil || num <= 0 {
+				return nil, errors.New(INVALID_MAX_REDIRECTS)
+			}
+			ctx.maxRedirects = num
 		}
-		ctx.printv(os.Stdout, "Got links", "Recursive link check done")
-	} else {
+		if arg == "--title-length" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			limits, err := parseLengthRange(value)
+			if err != nil {
+				return nil, err
+			}
+			ctx.titleLength = limits
+		}
+		if arg == "--description-length" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			limits, err := parseLengthRange(value)
+			if err != nil {
+				return nil, err
+			}
+			ctx.descriptionLength = limits
+		}
+		if arg == "--robots-unreachable" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			if value != "allow" && value != "disallow" {
+				return nil, errors.New(INVALID_ROBOTS_UNREACHABLE)
+			}
+			ctx.robotsUnreachable = value
+		}
+		if arg == "--user-agent" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			ctx.userAgent = value
+		}
+		if arg == "--rate" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate <= 0 {
+				return nil, errors.New(INVALID_RATE)
+			}
+			ctx.rate = rate
+		}
+		if arg == "--burst" {
+			value, err := flagValue(args, i, last)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			burst, err := strconv.Atoi(value)
+			if err != nil || burst <= 0 {
+				return nil, errors.New(INVALID_BURST)
+			}
+			ctx.burst = burst
+		}
+		if arg == "--dir" {
+			if i+1 >= last || len(args[i+1]) == 0 {
+				return nil, errors.New(ROOT_REQUIRED)
+			}
+			ctx.root = args[i+1]
+			i++
+		}
+	}
+	return tests, nil
+}
+
+func crawlUrl(url string, tests []string, ctx *Context) error {
+	urlData := splitUrl(url)
+	host := urlData["scheme"] + "://" + urlData["hostname"] + ":" + urlData["port"]
+	path := urlData["path"]
+
+	if urlData["scheme"] != "http" && urlData["scheme"] != "https" {
+		return errors.New(SCHEME_REQUIRED)
+	}
+	if urlData["hostname"] != "localhost" && urlData["hostname"] != "127.0.0.1" {
+		return errors.New(NON_LOCALHOST_CRAWL)
+	}
+	if len(urlData["port"]) == 0 {
+		return errors.New(INCLUDE_PORT)
+	}
+	return runTests(host, path, tests, ctx)
+}
+
+func crawlRoot(root string, path string, tests []string, ctx *Context) error {
+	/*
+	 * Crawl a build directory in-process. Requests never
+	 * leave crest: they are answered straight from the files
+	 * under root, so every check behaves as it does over HTTP.
+	 */
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(ROOT_NOT_DIRECTORY)
+	}
+	ctx.root = root
+	ctx.client = &http.Client{Transport: NewDirTransport(os.DirFS(root))}
+	return runTests(DIR_HOST, path, tests, ctx)
+}
+
+func serveRoot(root string, path string, tests []string, ctx *Context) error {
+	/*
+	 * Serve root on an ephemeral localhost port for the
+	 * duration of the crawl, so there is no need to start
+	 * (and wait for) a separate web server.
+	 */
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(ROOT_NOT_DIRECTORY)
+	}
+	ctx.root = root
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: NewFileServer(root)}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	host := "http://" + ln.Addr().String()
+	ctx.printv(os.Stdout, "Serving "+root, fmt.Sprintf("Serving %s at %s", root, host))
+	return crawlUrl(host+path, tests, ctx)
+}
+
+func Handle(args []string, ctx *Context) error {
+	/*
+	 * Handle commandline stuff.
+	 * The code is very self explanitory.
+	 * Will probably refactor at some point
+	 * if crest's commandline interface becomes
+	 * expressive enough.
+	 */
+	if len(args) <= 1 {
+		return errors.New(UNRECOGNIZED_COMMAND)
+	}
+
+	tests, err := parseFlags(args, true, ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(tests) == 0 {
 		return errors.New(INVALID_TEST)
 	}
-	return nil
+	return crawlUrl(args[len(args)-1], tests, ctx)
+}
+
+func HandleCrawl(args []string, ctx *Context) error {
+	/*
+	 * crest crawl --dir ./public
+	 * Link checks a build directory without a web server.
+	 */
+	tests, err := parseFlags(args, false, ctx)
+	if err != nil {
+		return err
+	}
+	if len(ctx.root) == 0 {
+		return errors.New(ROOT_REQUIRED)
+	}
+	return crawlRoot(ctx.root, "/", addTest(tests, "testHTTP"), ctx)
+}
+
+func HandleServe(args []string, ctx *Context) error {
+	/*
+	 * crest serve ./public
+	 * Serves a build directory on localhost and link checks it.
+	 */
+	if len(args) < 3 || strings.HasPrefix(args[len(args)-1], "-") {
+		return errors.New(SERVE_ROOT_REQUIRED)
+	}
+	tests, err := parseFlags(args, true, ctx)
+	if err != nil {
+		return err
+	}
+	return serveRoot(args[len(args)-1], "/", addTest(tests, "testHTTP"), ctx)
+}
+
+func HandleRobots(args []string, ctx *Context) error {
+	/*
+	 * crest robots lint http://localhost:8080
+	 * crest robots lint --dir ./public
+	 * Lints robots.txt without crawling the site.
+	 */
+	if len(args) < 4 || args[2] != "lint" {
+		return errors.New(UNRECOGNIZED_COMMAND)
+	}
+	if _, err := parseFlags(args, !slices.Contains(args, "--dir"), ctx); err != nil {
+		return err
+	}
+	tests := []string{"testRobots"}
+	if len(ctx.root) > 0 {
+		return crawlRoot(ctx.root, "/", tests, ctx)
+	}
+	return crawlUrl(args[len(args)-1], tests, ctx)
 }
 
 func HandleFile(args []string, s *State, ctx *Context) error {
@@ -431,8 +1054,9 @@ func HandleFile(args []string, s *State, ctx *Context) error {
 		return err
 	}
 
-	var test string
+	var tests []string
 	var url string
+	var serve string
 	instructions := s.instructionSet
 
 	// [type testHTTP verbose true followRobots true exclude hello exclude hello/world some-unrelated-tool ]
@@ -441,7 +1065,10 @@ func HandleFile(args []string, s *State, ctx *Context) error {
 		next := instructions[i+1]
 
 		if current == "type" {
-			test = next
+			if !slices.Contains(TEST_TYPES, next) {
+				return errors.New(INVALID_TEST)
+			}
+			tests = addTest(tests, next)
 		} else if current == "verbose" {
 			if next == "true" {
 				ctx.verbose = true
@@ -467,6 +1094,34 @@ func HandleFile(args []string, s *State, ctx *Context) error {
 			}
 		} else if current == "url" {
 			url = next
+		} else if current == "root" {
+			ctx.root = next
+		} else if current == "serve" {
+			serve = next
+		} else if current == "robotsUnreachable" {
+			if next != "allow" && next != "disallow" {
+				return errors.New(INVALID_ROBOTS_UNREACHABLE)
+			}
+			ctx.robotsUnreachable = next
+		} else if current == "userAgent" {
+			ctx.userAgent = next
+		} else if current == "rate" {
+			rate, err := strconv.ParseFloat(next, 64)
+			if err != nil || rate <= 0 {
+				return errors.New(INVALID_RATE)
+			}
+			ctx.rate = rate
+		} else if current == "burst" {
+			burst, err := strconv.Atoi(next)
+			if err != nil || burst <= 0 {
+				return errors.New(INVALID_BURST)
+			}
+			ctx.burst = burst
+		} else if current == "report" {
+			if _, _, err := parseReport(next); err != nil {
+				return err
+			}
+			ctx.reports = append(ctx.reports, next)
 		} else if current == "depth" {
 			num, err := strconv.Atoi(next)
 			if err != nil {
@@ -475,31 +1130,131 @@ func HandleFile(args []string, s *State, ctx *Context) error {
 			if num > 0 {
 				ctx.depth = num
 			}
+		} else if current == "failFast" {
+			if next == "true" {
+				ctx.failFast = true
+			}
+			if next == "false" {
+				ctx.failFast = false
+			}
+		} else if current == "assets" {
+			if next == "true" {
+				ctx.skipAssets = false
+			}
+			if next == "false" {
+				ctx.skipAssets = true
+			}
+		} else if current == "extract" {
+			extractor, err := parseLinkExtractor(next)
+			if err != nil {
+				return err
+			}
+			ctx.extractors = append(ctx.linkExtractors(), extractor)
+		} else if current == "checkFragments" {
+			if next == "true" {
+				ctx.checkFragments = true
+			}
+			if next == "false" {
+				ctx.checkFragments = false
+			}
+		} else if current == "orphans" {
+			if next == "true" {
+				ctx.orphans = true
+			}
+			if next == "false" {
+				ctx.orphans = false
+			}
+		} else if current == "sitemap" {
+			if next == "true" {
+				ctx.sitemap = true
+			}
+			if next == "false" {
+				ctx.sitemap = false
+			}
+		} else if current == "concurrency" {
+			num, err := strconv.Atoi(next)
+			if err != nil {
+				return err
+			}
+			if num <= 0 {
+				return errors.New(INVALID_CONCURRENCY)
+			}
+			ctx.concurrency = num
+		} else if current == "expectMissing" {
+			if err := ctx.expectStatus(next, http.StatusNotFound, http.StatusGone); err != nil {
+				return err
+			}
+		} else if current == "allowStatus" {
+			statuses, err := parseStatuses(next)
+			if err != nil {
+				return err
+			}
+			ctx.allowStatus = append(ctx.allowStatus, statuses...)
+		} else if current == "maxRedirects" {
+			num, err := strconv.Atoi(next)
+			if err != nil || num <= 0 {
+				return errors.New(INVALID_MAX_REDIRECTS)
+			}
+			ctx.maxRedirects = num
+		} else if current == "titleLength" {
+			limits, err := parseLengthRange(next)
+			if err != nil {
+				return err
+			}
+			ctx.titleLength = limits
+		} else if current == "descriptionLength" {
+			limits, err := parseLengthRange(next)
+			if err != nil {
+				return err
+			}
+			ctx.descriptionLength = limits
+		}
+	}
+	for _, statement := range s.statements {
+		if statement[0] == "redirect" {
+			if err := ctx.setRedirectPolicy(statement[1], statement[2]); err != nil {
+				return err
+			}
+		} else if statement[0] == "assert" {
+			assertion, err := newAssertion(statement[1], statement[2], statement[3], statement[4])
+			if err != nil {
+				return err
+			}
+			ctx.assertions = append(ctx.assertions, assertion)
+		} else if statement[0] == "select" {
+			selection, err := newSelection(statement[1], statement[2], statement[3], statement[4])
+			if err != nil {
+				return err
+			}
+			ctx.selections = append(ctx.selections, selection)
+		} else if statement[0] == "suppress" {
+			if err := ctx.suppress(statement[1], statement[2]); err != nil {
+				return err
+			}
+		} else if statement[0] == "expect" {
+			if statement[2] != "status" {
+				return errors.New(INVALID_EXPECT)
+			}
+			status, err := parseStatus(statement[3])
+			if err != nil {
+				return err
+			}
+			if err := ctx.expectStatus(statement[1], status); err != nil {
+				return err
+			}
 		}
 	}
 	ctx.printv(os.Stdout, "Successfully compiled crestfile instruction set", "")
 
-	if test == "testHTTP" {
-		urlData := splitUrl(url)
-		host := urlData["scheme"] + "://" + urlData["hostname"] + ":" + urlData["port"]
-		path := urlData["path"]
-
-		if urlData["scheme"] != "http" && urlData["scheme"] != "https" {
-			return errors.New(SCHEME_REQUIRED)
-		}
-		if urlData["hostname"] != "localhost" && urlData["hostname"] != "127.0.0.1" {
-			return errors.New(NON_LOCALHOST_CRAWL)
-		}
-		if len(urlData["port"]) == 0 {
-			return errors.New(INCLUDE_PORT)
-		}
-		if err := RecursiveLinkCheck(host, path, []string{}, ctx, 0); err != nil {
-			return err
-		}
-		ctx.printv(os.Stdout, "Got links", "Recursive link check done")
-	} else {
+	if len(tests) == 0 {
 		return errors.New(INVALID_TEST)
 	}
-
-	return nil
+	// With a root or served directory the url keyword only picks the start page.
+	if len(serve) > 0 {
+		return serveRoot(serve, splitUrl(url)["path"], tests, ctx)
+	}
+	if len(ctx.root) > 0 {
+		return crawlRoot(ctx.root, splitUrl(url)["path"], tests, ctx)
+	}
+	return crawlUrl(url, tests, ctx)
 }
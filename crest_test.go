@@ -1,14 +1,28 @@
 package main
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"io/ioutil"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/net/html"
 )
 
 const workdir = "/home/dmitri/repos/crawl-tester/"
@@ -43,6 +57,10 @@ func printTheThing(arg []LexNode) {
 func handleHtml(route string, path string) {
 	http.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
 		tmpl, err := template.ParseFiles(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -58,7 +76,7 @@ func handleHtml(route string, path string) {
 	})
 }
 
-func HttpTestsiteRun(wg *sync.WaitGroup) http.Server {
+func HttpTestsiteRun(wg *sync.WaitGroup) *http.Server {
 	handleHtml("/", "test_environment/index.html")
 	handleHtml("/ActuallyExists", "test_environment/ActuallyExists.html")
 	handleHtml("/AnotherWorkingSite", "test_environment/AnotherWorkingSite.html")
@@ -71,11 +89,16 @@ func HttpTestsiteRun(wg *sync.WaitGroup) http.Server {
 		w.Header().Set("Content-Type", "text/plain")
 		w.Write(content)
 	})
-	srv := http.Server{Addr: ":8080"}
+	srv := &http.Server{Addr: ":8080"}
 
+	// Listen before returning so the crawling tests never race the server.
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
 	wg.Add(1)
 	go func() {
-		err := srv.ListenAndServe()
+		err := srv.Serve(ln)
 		if err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
@@ -85,11 +108,40 @@ func HttpTestsiteRun(wg *sync.WaitGroup) http.Server {
 	return srv
 }
 
+// Serve a small site from memory for tests that need their own pages.
+func newTestSite(pages map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		contentType := mime.TypeByExtension(path.Ext(r.URL.Path))
+		if len(contentType) == 0 {
+			contentType = "text/html; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		fmt.Fprint(w, body)
+	}))
+}
+
+// runCrestfile writes raw to a temporary Crestfile and runs it.
+func runCrestfile(t *testing.T, raw string) (*Context, error) {
+	t.Helper()
+	crestfile := path.Join(t.TempDir(), "Crestfile")
+	if err := os.WriteFile(crestfile, []byte(raw), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	ctx := Context{}
+	err := HandleFile([]string{"crest", "run", crestfile}, &State{}, &ctx)
+	return &ctx, err
+}
+
 // Initialize testing server.
+// The server is kept alive for the rest of the tests.
 func TestInit(t *testing.T) {
 	var wg sync.WaitGroup
-	srv := HttpTestsiteRun(&wg)
-	defer srv.Close()
+	HttpTestsiteRun(&wg)
 	wg.Wait()
 }
 
@@ -107,7 +159,28 @@ func TestHttpCrawlingMinimalFlags(t *testing.T) {
 	if err == nil {
 		t.Fatalf("%v", err)
 	}
+	if len(ctx.failures) != 1 || ctx.failures[0].link.path != "/DoesNotExist" || ctx.failures[0].status != http.StatusNotFound {
+		t.Fatalf("unexpected failures %+v", ctx.failures)
+	}
+}
 
+// Empty arguments must not crash the flag parser, and flag values must not be parsed as flags.
+func TestFlagValues(t *testing.T) {
+	var ctx Context
+	if err := Handle([]string{"crest", "-t", "", "http://localhost:1"}, &ctx); err != nil && strings.Contains(err.Error(), FLAGS_PLACEMENT) {
+		t.Errorf("unexpected error %v", err)
+	}
+	ctx = Context{}
+	if err := Handle([]string{"crest", "-t", "--concurrency", "", "http://localhost:1"}, &ctx); err == nil || !strings.Contains(err.Error(), EMPTY_FLAG_VALUE) {
+		t.Errorf("expected an empty value error, got %v", err)
+	}
+	ctx = Context{}
+	if err := HandleCrawl([]string{"crest", "crawl", "--dir", "-q", "--concurrency"}, &ctx); err == nil || !strings.Contains(err.Error(), EMPTY_FLAG_VALUE) {
+		t.Errorf("expected a missing value error, got %v", err)
+	}
+	if ctx.root != "-q" || ctx.quiet {
+		t.Errorf("expected -q to be the directory, got %+v", ctx)
+	}
 }
 
 // Happy testing to test maximum flags.
@@ -123,6 +196,1298 @@ func TestHttpCrawlingAllFlags(t *testing.T) {
 	}
 }
 
+// Crawling with several workers must visit the same pages as crawling with one.
+func TestHttpCrawlingConcurrency(t *testing.T) {
+	var visited [][]string
+
+	for _, workers := range []int{1, 4} {
+		ctx := Context{followRobots: true, concurrency: workers}
+		args := []string{"crest", "-tq", "http://localhost:8080"}
+		if err := Handle(args, &ctx); err != nil {
+			t.Fatalf("%v", err)
+		}
+		visited = append(visited, ctx.visited.Links())
+	}
+
+	if !slices.Equal(visited[0], visited[1]) {
+		t.Fatalf("visited %v with one worker but %v with four", visited[0], visited[1])
+	}
+}
+
+// Every broken link is collected with the page that linked to it,
+// unless --fail-fast is given.
+func TestHttpCrawlingCollectFailures(t *testing.T) {
+	var ctx Context
+	args := []string{"crest", "-tq", "http://localhost:8080"}
+	if err := Handle(args, &ctx); err == nil {
+		t.Fatalf("expected broken links to fail the crawl")
+	}
+	if len(ctx.failures) != 1 {
+		t.Fatalf("expected 1 failure, got %d", len(ctx.failures))
+	}
+	failure := ctx.failures[0]
+	if failure.link.path != "/DoesNotExist" || failure.link.referrer != "/" || failure.status != http.StatusNotFound {
+		t.Fatalf("unexpected failure %+v", failure)
+	}
+	if !ctx.visited.Has("/AnotherWorkingSite") {
+		t.Fatalf("crawl stopped at the first broken link")
+	}
+
+	ctx = Context{}
+	args = []string{"crest", "-tq", "--fail-fast", "http://localhost:8080"}
+	var statusErr *StatusError
+	if err := Handle(args, &ctx); !errors.As(err, &statusErr) {
+		t.Fatalf("expected the first status error, got %v", err)
+	}
+	if len(ctx.failures) != 1 {
+		t.Fatalf("crawl continued after the first broken link")
+	}
+}
+
+// Relative hrefs resolve against the page URL, or its <base href> when present.
+func TestResolveLinks(t *testing.T) {
+	cases := []struct {
+		page  string
+		body  string
+		links []string
+	}{
+		{
+			page:  "http://localhost:8080/docs/guide/index.html",
+			body:  `<a href="intro.html">a</a><a href="../img/">b</a><a href="/top?x=1">c</a><a href="//localhost:8080/abs">d</a>`,
+			links: []string{"/docs/guide/intro.html", "/docs/img/", "/top?x=1", "/abs"},
+		},
+		{
+			page:  "http://localhost:8080/docs/guide/",
+			body:  `<head><base href="/static/v2/"></head><a href="page.html">a</a><a href="../v1/">b</a>`,
+			links: []string{"/static/v2/page.html", "/static/v1/"},
+		},
+		{
+			page:  "http://localhost:8080/",
+			body:  `<a href="https://example.com/">a</a><a href="mailto:me@localhost">b</a><a href="#top">c</a><a href="http://localhost:9090/">d</a>`,
+			links: []string{"/"},
+		},
+	}
+
+	for _, c := range cases {
+		pageUrl, err := url.Parse(c.page)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		node, err := html.Parse(strings.NewReader(c.body))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var got []string
+		for _, link := range getPageLinksTask(node, pageUrl, DEFAULT_EXTRACTORS) {
+			got = append(got, link.path)
+		}
+		if !slices.Equal(got, c.links) {
+			t.Errorf("links of %s: expected %v, got %v", c.page, c.links, got)
+		}
+	}
+}
+
+// Assets referenced by a page are extracted alongside its anchors.
+func TestAssetLinks(t *testing.T) {
+	body := `<html><head>
+		<link rel="stylesheet" href="/style.css"><link rel="icon" href="favicon.ico">
+		<link rel="preconnect" href="http://localhost:8080"><script src="app.js"></script>
+	</head><body>
+		<a href="/page">page</a>
+		<img src="a.png" srcset="a-1x.png 1x, a-2x.png 2x,a-3x.png 3x">
+		<picture><source srcset="b.webp 100w, data/b,large.webp 800w"></picture>
+		<video src="v.mp4" poster="poster.jpg"></video>
+		<iframe src="/embed"></iframe><object data="doc.pdf"></object>
+	</body></html>`
+	expected := []string{
+		"/style.css", "/favicon.ico", "/app.js", "/page", "/a.png", "/a-1x.png", "/a-2x.png", "/a-3x.png",
+		"/b.webp", "/data/b,large.webp", "/v.mp4", "/poster.jpg", "/embed", "/doc.pdf",
+	}
+
+	pageUrl, _ := url.Parse("http://localhost:8080/")
+	node, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var got []string
+	for _, link := range getPageLinksTask(node, pageUrl, DEFAULT_EXTRACTORS) {
+		got = append(got, link.path)
+		if link.asset == (link.path == "/page") {
+			t.Errorf("%s has asset set to %v", link.path, link.asset)
+		}
+	}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+// url() and @import references of stylesheets resolve against the stylesheet.
+func TestCssLinks(t *testing.T) {
+	css := `@import "base.css";
+		@import url('print.css') print;
+		/* url(commented.png) */
+		@font-face { src: url("../fonts/a.woff2") format("woff2"), url(../fonts/a.woff); }
+		.hero { background: url( img/hero.jpg ) no-repeat, url(data:image/png;base64,AAAA); }`
+	expected := []string{"/css/base.css", "/css/print.css", "/fonts/a.woff2", "/fonts/a.woff", "/css/img/hero.jpg"}
+
+	cssUrl, _ := url.Parse("http://localhost:8080/css/site.css")
+	var got []string
+	for _, link := range getCssLinks(css, cssUrl, cssUrl) {
+		got = append(got, link.path)
+	}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	pageUrl, _ := url.Parse("http://localhost:8080/blog/post.html")
+	node, err := html.Parse(strings.NewReader(`<style>body { background: url(bg.png) }</style><div style="background-image: url('/hero.png')"></div>`))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got = nil
+	for _, link := range getInlineCssLinks(node, pageUrl) {
+		got = append(got, link.path)
+	}
+	if !slices.Equal(got, []string{"/blog/bg.png", "/hero.png"}) {
+		t.Fatalf("unexpected inline css links %v", got)
+	}
+}
+
+// Fragment links must point at an id or name that exists on the target page.
+func TestFragmentLinks(t *testing.T) {
+	site := newTestSite(map[string]string{
+		"/":      `<h1 id="intro">Intro</h1><a href="#intro">ok</a><a href="#missing">bad</a><a href="#top">top</a><a href="/guide#install">ok</a><a href="/guide#setup">renamed</a>`,
+		"/guide": `<h2 id="install">Install</h2><a name="legacy"></a><a href="/#intro">back</a><a href="#legacy">ok</a>`,
+	})
+	defer site.Close()
+
+	ctx := Context{quiet: true}
+	if err := Handle([]string{"crest", "-t", site.URL}, &ctx); err != nil {
+		t.Fatalf("fragments are not checked by default: %v", err)
+	}
+
+	ctx = Context{quiet: true}
+	if err := Handle([]string{"crest", "-t", "--check-fragments", site.URL}, &ctx); err == nil {
+		t.Fatalf("expected broken fragments to fail the crawl")
+	}
+	var broken []string
+	for _, failure := range ctx.failures {
+		broken = append(broken, failure.link.path+"#"+failure.link.fragment)
+	}
+	if !slices.Equal(broken, []string{"/#missing", "/guide#setup"}) {
+		t.Fatalf("unexpected broken fragments %v", broken)
+	}
+}
+
+// Write a small build directory for the directory crawling tests.
+func newTestDir(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		if err := os.MkdirAll(path.Join(root, path.Dir(name)), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := os.WriteFile(path.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	return root
+}
+
+var testBuild = map[string]string{
+	"index.html":      `<link rel="stylesheet" href="style.css"><a href="about">about</a><a href="docs/">docs</a>`,
+	"about.html":      `<a href="/docs">docs</a>`,
+	"style.css":       `body { background: url(img/bg.png) }`,
+	"img/bg.png":      "png",
+	"docs/index.html": `<a href="intro.html">intro</a><a href="missing.html">missing</a>`,
+	"docs/intro.html": `<a href="../">home</a><a href="index.html">docs</a>`,
+}
+
+func checkTestBuildCrawl(t *testing.T, ctx *Context) {
+	if len(ctx.failures) != 1 || ctx.failures[0].link.path != "/docs/missing.html" || ctx.failures[0].status != http.StatusNotFound {
+		t.Fatalf("unexpected failures %+v", ctx.failures)
+	}
+	for _, link := range []string{"/about", "/docs/", "/docs", "/docs/intro.html", "/docs/index.html", "/style.css", "/img/bg.png"} {
+		if !ctx.visited.Has(link) {
+			t.Errorf("%s was not crawled", link)
+		}
+	}
+	for _, page := range ctx.pages {
+		if page.link.path == "/docs/index.html" && (page.status != http.StatusOK || len(page.redirects) > 0) {
+			t.Errorf("expected /docs/index.html to be served without a redirect, got %d %v", page.status, page.redirects)
+		}
+	}
+}
+
+// A build directory is crawled in-process with index.html and pretty URL rules.
+func TestDirCrawling(t *testing.T) {
+	root := newTestDir(t, testBuild)
+
+	ctx := Context{quiet: true}
+	if err := HandleCrawl([]string{"crest", "crawl", "--dir", root}, &ctx); err == nil {
+		t.Fatalf("expected the missing page to fail the crawl")
+	}
+	checkTestBuildCrawl(t, &ctx)
+}
+
+// Without a URL to keep last, flags of crest crawl can also come after --dir.
+func TestDirCrawlingFlagsLast(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(path.Join(root, "index.html"), []byte(`<a href="missing.html">missing</a>`), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ctx := Context{}
+	if err := HandleCrawl([]string{"crest", "crawl", "--dir", root, "--fail-fast", "--concurrency", "4", "-q"}, &ctx); err == nil {
+		t.Fatalf("expected the missing page to fail the crawl")
+	}
+	if !ctx.failFast || ctx.concurrency != 4 || !ctx.quiet {
+		t.Errorf("flags after --dir were not parsed: %+v", ctx)
+	}
+}
+
+// crest serve finds the same pages as crawling the directory in-process.
+func TestServeCrawling(t *testing.T) {
+	root := newTestDir(t, testBuild)
+
+	ctx := Context{quiet: true}
+	if err := HandleServe([]string{"crest", "serve", root}, &ctx); err == nil {
+		t.Fatalf("expected the missing page to fail the crawl")
+	}
+	checkTestBuildCrawl(t, &ctx)
+}
+
+// The file server of crest serve answers every path like crawling the directory does.
+func TestFileServerRules(t *testing.T) {
+	files := map[string]string{"docs.html": "docs page", "guide/index.html": "guide"}
+	for name, content := range testBuild {
+		files[name] = content
+	}
+	root := newTestDir(t, files)
+	srv := httptest.NewServer(NewFileServer(root))
+	defer srv.Close()
+	transport := NewDirTransport(os.DirFS(root))
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	for urlPath, status := range map[string]int{
+		"/":                http.StatusOK,
+		"/about":           http.StatusOK,
+		"/docs":            http.StatusOK,
+		"/docs/":           http.StatusOK,
+		"/docs/index.html": http.StatusOK,
+		"/guide":           http.StatusMovedPermanently,
+		"/img/":            http.StatusNotFound,
+		"/img":             http.StatusNotFound,
+		"/missing":         http.StatusNotFound,
+	} {
+		res, err := client.Get(srv.URL + urlPath)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		res.Body.Close()
+		req, err := http.NewRequest("GET", DIR_HOST+urlPath, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dirRes, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if res.StatusCode != status || dirRes.StatusCode != status {
+			t.Errorf("%s: expected %d, got %d served and %d from the directory", urlPath, status, res.StatusCode, dirRes.StatusCode)
+		}
+	}
+}
+
+// HTML files nothing links to are reported, under either way of crawling a directory.
+func TestOrphanFiles(t *testing.T) {
+	files := map[string]string{
+		"old.html":            "stale",
+		"docs/old/index.html": "stale",
+		"404.html":            "not found",
+	}
+	for name, content := range testBuild {
+		files[name] = content
+	}
+	root := newTestDir(t, files)
+
+	for _, args := range [][]string{
+		{"crest", "crawl", "--orphans", "--dir", root},
+		{"crest", "serve", "--orphans", root},
+	} {
+		ctx := Context{quiet: true, exclude: []string{"/404.html"}}
+		var err error
+		if args[1] == "crawl" {
+			err = HandleCrawl(args, &ctx)
+		} else {
+			err = HandleServe(args, &ctx)
+		}
+		if err == nil {
+			t.Fatalf("%s: expected orphan files to fail the crawl", args[1])
+		}
+		var orphans []string
+		for _, failure := range ctx.failures {
+			if failure.check == "orphan" {
+				orphans = append(orphans, failure.link.path)
+			}
+		}
+		if expected := []string{"/docs/old/", "/old.html"}; !slices.Equal(orphans, expected) {
+			t.Errorf("%s: expected orphans %v, got %v", args[1], expected, orphans)
+		}
+	}
+
+	ctx := Context{quiet: true}
+	if err := Handle([]string{"crest", "-t", "--orphans", "http://localhost:8080"}, &ctx); err == nil || err.Error() != ORPHANS_ROOT_REQUIRED {
+		t.Errorf("expected %q, got %v", ORPHANS_ROOT_REQUIRED, err)
+	}
+}
+
+// The JSON report lists every visited URL with its referrers, and every failure.
+func TestJsonReport(t *testing.T) {
+	site := newTestSite(map[string]string{
+		"/":          `<a href="/a">a</a><a href="/b">b</a><img src="/logo.png">`,
+		"/a":         `<a href="/b">b</a><a href="/gone">gone</a>`,
+		"/b":         `<a href="/">home</a>`,
+		"/logo.png":  "png",
+		"/style.css": "",
+	})
+	defer site.Close()
+
+	reportPath := path.Join(t.TempDir(), "report.json")
+	ctx := Context{quiet: true}
+	if err := Handle([]string{"crest", "-t", "--report", "json=" + reportPath, site.URL}, &ctx); err == nil {
+		t.Fatalf("expected /gone to fail the crawl")
+	}
+
+	raw, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var report Report
+	if err := json.Unmarshal(raw, &report); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if report.SchemaVersion != REPORT_SCHEMA_VERSION {
+		t.Fatalf("unexpected schema version %d", report.SchemaVersion)
+	}
+
+	var paths []string
+	for _, page := range report.Pages {
+		paths = append(paths, page.Path)
+		if page.Path == "/b" && !slices.Equal(page.Referrers, []string{"/", "/a"}) {
+			t.Errorf("unexpected referrers of /b: %v", page.Referrers)
+		}
+		if page.Path == "/logo.png" && (!page.Asset || page.ContentType != "image/png" || page.Depth != 1) {
+			t.Errorf("unexpected asset record %+v", page)
+		}
+	}
+	if !slices.Equal(paths, []string{"/", "/a", "/b", "/logo.png", "/gone"}) {
+		t.Fatalf("unexpected pages %v", paths)
+	}
+	if len(report.Failures) != 1 || report.Failures[0].Url != site.URL+"/gone" || report.Failures[0].Status != http.StatusNotFound || report.Failures[0].Referrer != "/a" {
+		t.Fatalf("unexpected failures %+v", report.Failures)
+	}
+}
+
+// Broken links become JUnit failures on the test case of the page to fix.
+func TestJunitReport(t *testing.T) {
+	site := newTestSite(map[string]string{
+		"/":  `<a href="/a">a</a><a href="/a#missing">missing</a>`,
+		"/a": `<a href="/gone">gone</a>`,
+	})
+	defer site.Close()
+
+	reportPath := path.Join(t.TempDir(), "junit.xml")
+	ctx := Context{quiet: true}
+	args := []string{"crest", "-t", "--check-fragments", "--report", "junit=" + reportPath, site.URL}
+	if err := Handle(args, &ctx); err == nil {
+		t.Fatalf("expected the crawl to fail")
+	}
+
+	raw, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var report JunitTestsuites
+	if err := xml.Unmarshal(raw, &report); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if report.Tests != 5 || report.Failures != 2 || len(report.Testsuites) != 2 {
+		t.Fatalf("unexpected totals %+v", report)
+	}
+
+	for _, suite := range report.Testsuites {
+		for _, testcase := range suite.Testcases {
+			if len(testcase.Failures) == 0 {
+				continue
+			}
+			message := testcase.Failures[0].Message
+			if suite.Name == "http" && (testcase.Name != site.URL+"/gone" || !strings.Contains(message, "404") || !strings.Contains(message, "/a")) {
+				t.Errorf("unexpected http failure %s: %s", testcase.Name, message)
+			}
+			if suite.Name == "fragment" && (testcase.Name != site.URL+"/" || !strings.Contains(message, "/a#missing")) {
+				t.Errorf("unexpected fragment failure %s: %s", testcase.Name, message)
+			}
+		}
+	}
+}
+
+// robots.txt matching follows the examples of RFC 9309.
+func TestRobotsMatching(t *testing.T) {
+	// Section 2.2.2: the longest match wins, Allow wins ties.
+	precedence := []struct {
+		allow    string
+		disallow string
+		path     string
+		allowed  bool
+	}{
+		{"/p", "/", "/page", true},
+		{"/folder", "/folder", "/folder/page", true},
+		{"/page", "/*.htm", "/page.htm", false},
+		{"/page", "/*.ph", "/page.php5", true},
+		{"/$", "/", "/", true},
+		{"/$", "/", "/page.htm", false},
+	}
+	for _, c := range precedence {
+		rules := RobotPolicy{allow: []string{c.allow}, disallow: []string{c.disallow}}
+		if allowed, _ := robotsAllowed(rules, c.path); allowed != c.allowed {
+			t.Errorf("Allow: %s, Disallow: %s on %s: expected allowed=%v", c.allow, c.disallow, c.path, c.allowed)
+		}
+	}
+
+	// Section 2.2.3: special characters and percent-encoding.
+	patterns := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/path/file-with-a-*.html", "/path/file-with-a-star.html", true},
+		{"/path/foo-$", "/path/foo-", true},
+		{"/path/foo-$", "/path/foo-bar", false},
+		{"/foo/bar?baz=quz", "/foo/bar?baz=quz", true},
+		{"/foo/bar/ツ", "/foo/bar/%E3%83%84", true},
+		{"/foo/bar/%E3%83%84", "/foo/bar/%E3%83%84", true},
+		{"/foo/bar/%62%61%7A", "/foo/bar/%62%61%7A", true},
+		{"/private/", "/private/a.html", true},
+		{"/private/", "/private", false},
+		{"/*.gif$", "/images/a.gif", true},
+		{"/*.gif$", "/images/a.gif?size=2", false},
+		{"/a/*/c", "/a/b/x/c/d", true},
+		{"*", "/anything", true},
+	}
+	for _, c := range patterns {
+		if robotsPatternMatch(c.pattern, c.path) != c.match {
+			t.Errorf("pattern %s on %s: expected match=%v", c.pattern, c.path, c.match)
+		}
+	}
+
+	// Section 5.1: grouping, agent matching and the * fallback.
+	policies := parseRobots(`
+User-Agent: *
+Disallow: *.gif$
+Disallow: /example/
+Allow: /publications/
+
+User-Agent: foobot
+Disallow:/
+Allow:/example/page.html
+Allow:/example/allowed.gif
+
+User-Agent: barbot
+User-Agent: bazbot
+Disallow: /example/page.html
+
+User-Agent: quxbot
+
+# Groups naming the same agent are merged.
+user-agent: FooBot
+disallow: /extra # comment
+`)
+	groups := []struct {
+		agent   string
+		path    string
+		allowed bool
+	}{
+		{"foobot", "/example/page.html", true},
+		{"foobot", "/example/allowed.gif", true},
+		{"foobot", "/example/other.html", false},
+		{"foobot", "/extra", false},
+		{"FooBot/1.0", "/", false},
+		{"barbot", "/example/page.html", false},
+		{"bazbot", "/example/page.html", false},
+		{"bazbot", "/example/other.html", true},
+		{"quxbot", "/example/page.html", true},
+		{"otherbot", "/example/page.html", false},
+		{"otherbot", "/images/a.gif", false},
+		{"otherbot", "/publications/a.gif", true},
+		{"otherbot", "/publications/a.html", true},
+		{"otherbot", "/robots.txt", true},
+	}
+	for _, c := range groups {
+		if allowed, _ := robotsAllowed(robotRulesFor(policies, c.agent), c.path); allowed != c.allowed {
+			t.Errorf("%s on %s: expected allowed=%v", c.agent, c.path, c.allowed)
+		}
+	}
+
+	// Without any matching group everything is allowed.
+	if allowed, _ := robotsAllowed(robotRulesFor(parseRobots("User-agent: foobot\nDisallow: /"), "crestbot"), "/a"); !allowed {
+		t.Errorf("expected everything to be allowed without a matching group")
+	}
+}
+
+// robots.txt is fetched once per crawl and its status decides what is allowed.
+func TestRobotsCache(t *testing.T) {
+	var robotsStatus int
+	var robotsRequests int
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			mu.Lock()
+			robotsRequests++
+			mu.Unlock()
+			w.WriteHeader(robotsStatus)
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+			return
+		}
+		fmt.Fprint(w, `<a href="/a">a</a><a href="/b">b</a><a href="/private/c">c</a>`)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		status      int
+		unreachable string
+		visited     []string
+	}{
+		{http.StatusOK, "", []string{"/", "/a", "/b"}},
+		{http.StatusNotFound, "", []string{"/", "/a", "/b", "/private/c"}},
+		{http.StatusServiceUnavailable, "", []string{"/"}},
+		{http.StatusServiceUnavailable, "allow", []string{"/", "/a", "/b", "/private/c"}},
+	}
+	for _, c := range cases {
+		robotsStatus = c.status
+		robotsRequests = 0
+		ctx := Context{quiet: true, followRobots: true, robotsUnreachable: c.unreachable}
+		if err := Handle([]string{"crest", "-t", srv.URL}, &ctx); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if visited := ctx.visited.Links(); !slices.Equal(visited, c.visited) {
+			t.Errorf("robots.txt status %d: expected %v, got %v", c.status, c.visited, visited)
+		}
+		if robotsRequests != 1 {
+			t.Errorf("robots.txt status %d: fetched %d times", c.status, robotsRequests)
+		}
+	}
+}
+
+// The user agent is sent with every request and picks the robots.txt group.
+func TestUserAgent(t *testing.T) {
+	var mu sync.Mutex
+	agents := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.Header.Get("User-Agent")] = true
+		mu.Unlock()
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: Googlebot\nDisallow: /nogoogle\n\nUser-agent: *\nDisallow: /private\n")
+			return
+		}
+		fmt.Fprint(w, `<a href="/nogoogle">a</a><a href="/private">b</a>`)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		args    []string
+		agent   string
+		visited []string
+	}{
+		{[]string{"crest", "-tf", srv.URL}, "Crestbot", []string{"/", "/nogoogle"}},
+		{[]string{"crest", "-tf", "--user-agent", "Googlebot/2.1 (+http://www.google.com/bot.html)", srv.URL}, "Googlebot/2.1 (+http://www.google.com/bot.html)", []string{"/", "/private"}},
+	}
+	for _, c := range cases {
+		agents = make(map[string]bool)
+		ctx := Context{quiet: true}
+		if err := Handle(c.args, &ctx); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if len(agents) != 1 || !agents[c.agent] {
+			t.Errorf("expected every request to be sent as %s, got %v", c.agent, agents)
+		}
+		if visited := ctx.visited.Links(); !slices.Equal(visited, c.visited) {
+			t.Errorf("as %s: expected %v, got %v", c.agent, c.visited, visited)
+		}
+	}
+}
+
+// Every kind of robots.txt mistake is reported on its own line.
+func TestRobotsLint(t *testing.T) {
+	srv := newTestSite(map[string]string{
+		"/robots.txt": "Disallow: /early\n" +
+			"User-agent: *\n" +
+			"disallow: /lower\n" +
+			"Allow /nocolon\n" +
+			"Noindex: /x\n" +
+			"Disallow: private\n" +
+			"Disallow: /a$b\n" +
+			"Disallow: /dup\n" +
+			"Disallow: /dup\n" +
+			"Allow: /same\n" +
+			"Disallow: /same\n" +
+			"Crawl-delay: soon\n" +
+			"Disallow: /blocked\n" +
+			"Sitemap: https://example.com/sitemap.xml\n" +
+			"Sitemap: https://example.com/missing.xml\n" +
+			"Sitemap: /relative.xml\n" +
+			"Allow: /news/*\n" +
+			"Disallow: /news/\n" +
+			"Disallow: /tmp*\n" +
+			"Allow: /tmp\n" +
+			"Allow: /shop\n" +
+			"Disallow: /shop/cart\n",
+		"/":            `<a href="/blocked">blocked</a><a href="/ok">ok</a>`,
+		"/sitemap.xml": `<urlset></urlset>`,
+	})
+	defer srv.Close()
+
+	ctx := Context{quiet: true}
+	err := HandleRobots([]string{"crest", "robots", "lint", srv.URL}, &ctx)
+	if err == nil || !strings.HasPrefix(err.Error(), CHECKS_FAILED) {
+		t.Fatalf("expected robots.txt problems, got %v", err)
+	}
+	var lines []int
+	for _, failure := range ctx.failures {
+		if failure.check != "robots" {
+			t.Errorf("unexpected %s failure %v", failure.check, failure.err)
+		}
+		lines = append(lines, failure.line)
+	}
+	slices.Sort(lines)
+	if expected := []int{1, 3, 4, 5, 6, 7, 9, 11, 12, 13, 15, 16, 18, 20}; !slices.Equal(lines, expected) {
+		t.Errorf("expected problems on lines %v, got %v", expected, lines)
+	}
+	if ctx.visited != nil {
+		t.Errorf("linting robots.txt should not crawl, visited %v", ctx.visited.Links())
+	}
+
+	// A group naming the user agent overrides the * groups.
+	srv = newTestSite(map[string]string{
+		"/robots.txt": "User-agent: *\nDisallow: /\n\nUser-agent: Crestbot\nAllow: /\n",
+		"/":           `<a href="/ok">ok</a>`,
+	})
+	defer srv.Close()
+	ctx = Context{quiet: true}
+	if err := HandleRobots([]string{"crest", "robots", "lint", srv.URL}, &ctx); err == nil {
+		t.Fatalf("expected the * group to be reported")
+	}
+	if len(ctx.failures) != 1 || ctx.failures[0].line != 1 {
+		t.Errorf("expected a problem on line 1, got %+v", ctx.failures)
+	}
+}
+
+// Sitemap entries seed the crawl and crawled pages missing from the sitemap are reported.
+func TestSitemap(t *testing.T) {
+	srv := newTestSite(map[string]string{
+		"/robots.txt": "User-agent: *\nSitemap: https://example.com/sitemap_index.xml\n",
+		"/sitemap_index.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/pages.xml</loc></sitemap>
+  <sitemap><loc>https://example.com/missing.xml</loc></sitemap>
+</sitemapindex>`,
+		"/pages.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/</loc></url>
+  <url><loc>https://example.com/listed</loc></url>
+  <url><loc>https://example.com/gone</loc></url>
+</urlset>`,
+		"/":       `<a href="/linked">linked</a>`,
+		"/listed": `listed`,
+		"/linked": `linked`,
+	})
+	defer srv.Close()
+
+	ctx := Context{quiet: true}
+	if err := Handle([]string{"crest", "-t", "--sitemap", srv.URL}, &ctx); err == nil {
+		t.Fatalf("expected sitemap failures")
+	}
+	if visited, expected := ctx.visited.Links(), []string{"/", "/gone", "/linked", "/listed"}; !slices.Equal(visited, expected) {
+		t.Errorf("expected %v, got %v", expected, visited)
+	}
+	var failures []string
+	for _, failure := range ctx.failures {
+		failures = append(failures, fmt.Sprintf("%s %s %s", failure.check, failure.link.path, failure.link.referrer))
+	}
+	expected := []string{"sitemap /missing.xml ", "http /gone /pages.xml", "sitemap /linked /"}
+	if !slices.Equal(failures, expected) {
+		t.Errorf("expected failures %q, got %q", expected, failures)
+	}
+
+	// Without Sitemap lines in robots.txt, /sitemap.xml is read.
+	srv = newTestSite(map[string]string{
+		"/sitemap.xml":     `<urlset><url><loc>http://localhost/</loc></url><url><loc>http://localhost/only-in-sitemap</loc></url></urlset>`,
+		"/":                `home`,
+		"/only-in-sitemap": `only in sitemap`,
+	})
+	defer srv.Close()
+	ctx = Context{quiet: true}
+	if err := Handle([]string{"crest", "-t", "--sitemap", srv.URL}, &ctx); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if visited, expected := ctx.visited.Links(), []string{"/", "/only-in-sitemap"}; !slices.Equal(visited, expected) {
+		t.Errorf("expected %v, got %v", expected, visited)
+	}
+}
+
+// Redirected pages are looked up in the sitemap by their final path, and expected paths are not crawl discoveries.
+func TestSitemapOrphans(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprint(w, `<urlset><url><loc>https://example.com/</loc></url><url><loc>https://example.com/new</loc></url></urlset>`)
+		case "/old", "/moved":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/":
+			fmt.Fprint(w, `<a href="/old">old</a><a href="/moved">moved</a><a href="/about">about</a>`)
+		case "/new", "/about", "/hidden":
+			fmt.Fprintf(w, "<p>%s</p>", r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	raw := "url " + srv.URL + "\ntype testHTTP\nquiet true\nsitemap true\nexpect \"/hidden\" status 200\n"
+	ctx, err := runCrestfile(t, raw)
+	if err == nil {
+		t.Fatalf("expected /about to be missing from the sitemap")
+	}
+	var missing []string
+	for _, failure := range ctx.failures {
+		if failure.check == "sitemap" {
+			missing = append(missing, failure.link.path)
+		}
+	}
+	if expected := []string{"/about"}; !slices.Equal(missing, expected) {
+		t.Errorf("expected %v missing from the sitemap, got %v", expected, missing)
+	}
+}
+
+// The rate limiter spaces requests out after the burst, and Crawl-delay slows it down further.
+func TestRateLimiter(t *testing.T) {
+	limiter := NewHostLimiter(50, 2)
+	start := time.Now()
+	for range 6 {
+		limiter.Wait("http://localhost:8080")
+	}
+	// Only the lower bound is checked, a busy machine can always be slower.
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("6 requests at 50/s with a burst of 2 took only %v", elapsed)
+	}
+
+	rules := robotRulesFor(parseRobots("User-agent: *\nCrawl-delay: 0.05\nDisallow: /private/\n"), "Crestbot")
+	if rules.crawlDelay != 0.05 || len(rules.disallow) != 1 {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+	limiter = NewHostLimiter(1000, 10)
+	limiter.AdoptCrawlDelay("http://localhost:8080", rules.crawlDelay)
+	start = time.Now()
+	for range 3 {
+		limiter.Wait("http://localhost:8080")
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Crawl-delay of 0.05s was not adopted, 3 requests took %v", elapsed)
+	}
+}
+
+// Redirect chains are recorded, checked and judged by the redirect policies.
+func TestRedirects(t *testing.T) {
+	redirects := map[string]string{
+		"/moved":  "/new",
+		"/temp":   "/new",
+		"/loop-a": "/loop-b",
+		"/loop-b": "/loop-a",
+		"/long":   "/long1",
+		"/long1":  "/long2",
+		"/long2":  "/long3",
+		"/long3":  "/new",
+		"/away":   "http://example.com/",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, ok := redirects[r.URL.Path]; ok {
+			status := http.StatusMovedPermanently
+			if r.URL.Path == "/temp" {
+				status = http.StatusFound
+			}
+			http.Redirect(w, r, target, status)
+			return
+		}
+		fmt.Fprint(w, `<a href="/moved">a</a><a href="/temp">b</a><a href="/loop-a">c</a><a href="/long">d</a><a href="/away">e</a>`)
+	}))
+	defer srv.Close()
+
+	ctx := Context{quiet: true}
+	args := []string{"crest", "-t", "--redirect", "301=warn", "--redirect", "302=fail", "--max-redirects", "3", srv.URL}
+	if err := Handle(args, &ctx); err == nil {
+		t.Fatalf("expected redirect failures")
+	}
+	var failures []string
+	for _, failure := range ctx.failures {
+		if failure.check != "redirect" {
+			t.Errorf("unexpected %s failure %v", failure.check, failure.err)
+		}
+		failures = append(failures, failure.link.path)
+	}
+	if expected := []string{"/temp", "/loop-a", "/long", "/away"}; !slices.Equal(failures, expected) {
+		t.Errorf("expected failures %v, got %v", expected, failures)
+	}
+	for i, problem := range []string{"302 redirect", REDIRECT_LOOP, REDIRECT_TOO_LONG, REDIRECT_OFFSITE} {
+		if i < len(ctx.failures) && !strings.HasPrefix(ctx.failures[i].err.Error(), problem) {
+			t.Errorf("expected %q, got %q", problem, ctx.failures[i].err)
+		}
+	}
+	if len(ctx.warnings) != 1 || ctx.warnings[0].link.path != "/moved" || ctx.warnings[0].status != http.StatusMovedPermanently {
+		t.Errorf("unexpected warnings %+v", ctx.warnings)
+	}
+	for _, page := range ctx.pages {
+		if page.link.path == "/moved" && (len(page.redirects) != 1 || page.url != srv.URL+"/new") {
+			t.Errorf("unexpected chain %+v to %s", page.redirects, page.url)
+		}
+	}
+
+	// Leaving https for http is never followed.
+	tls := httptest.NewTLSServer(http.RedirectHandler("http://localhost/", http.StatusMovedPermanently))
+	defer tls.Close()
+	ctx = Context{quiet: true, client: tls.Client()}
+	if _, _, err := fetchWithRedirects(tls.URL, "/", &ctx); err == nil || !strings.HasPrefix(err.Error(), REDIRECT_DOWNGRADE) {
+		t.Errorf("expected %q, got %v", REDIRECT_DOWNGRADE, err)
+	}
+
+	// Crestfiles declare policies with the redirect statement.
+	s := State{raw: "maxRedirects 2\nredirect 308 fail\nverbose true\n"}
+	if err := s.Lexer(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := s.Parser(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := s.Compiler(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(s.statements) != 1 || !slices.Equal(s.statements[0], []string{"redirect", "308", "fail"}) {
+		t.Errorf("unexpected statements %q", s.statements)
+	}
+	if !slices.Equal(s.instructionSet, []string{"maxRedirects", "2", "verbose", "true"}) {
+		t.Errorf("unexpected instructions %q", s.instructionSet)
+	}
+	s = State{raw: "redirect 301\n"}
+	s.Lexer()
+	if err := s.Parser(); err == nil {
+		t.Errorf("expected redirect without a policy to fail parsing")
+	}
+}
+
+// Expected and allowed statuses pass, any other status still fails.
+func TestExpectedStatus(t *testing.T) {
+	statuses := map[string]int{
+		"/empty":        http.StatusNoContent,
+		"/gone":         http.StatusGone,
+		"/legal":        http.StatusUnavailableForLegalReasons,
+		"/archive/2019": http.StatusGone,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/", http.StatusMovedPermanently)
+			return
+		}
+		if status, ok := statuses[r.URL.Path]; ok {
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprint(w, `<a href="/empty">a</a><a href="/gone">b</a><a href="/legal">c</a><a href="/restored">d</a><a href="/old">e</a><a href="/archive/2019">f</a>`)
+	}))
+	defer srv.Close()
+
+	raw := "url " + srv.URL + "\ntype testHTTP\nquiet true\nallowStatus \"204\"\n" +
+		"expect \"/gone\" status 410\nexpect \"/restored\" status 410\nexpect \"/old\" status 301\nexpect \"/archive/*\" status 410\n"
+	ctx, err := runCrestfile(t, raw)
+	if err == nil {
+		t.Fatalf("expected unexpected statuses to fail the crawl")
+	}
+	var failures []string
+	for _, failure := range ctx.failures {
+		failures = append(failures, fmt.Sprintf("%s %d %s", failure.link.path, failure.status, failure.message()))
+	}
+	expected := []string{"/legal 451 Unavailable For Legal Reasons", "/restored 200 OK, expected 410"}
+	if !slices.Equal(failures, expected) {
+		t.Errorf("expected failures %q, got %q", expected, failures)
+	}
+
+	if !newPathPattern("/docs/*").Match("/docs/a/b.html") || newPathPattern("/docs/*").Match("/doc") || !newPathPattern("*.pdf").Match("/files/a.pdf") || newPathPattern("/a.b").Match("/axb") {
+		t.Errorf("unexpected PathPattern matches")
+	}
+	if err := (&Context{}).expectStatus("gone", http.StatusGone); err == nil || !strings.Contains(err.Error(), INVALID_EXPECT_PATH) {
+		t.Errorf("expected a relative path to be rejected, got %v", err)
+	}
+}
+
+// Expected paths are requested even when no page links to them.
+func TestExpectMissing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `home`)
+		case "/admin":
+			w.WriteHeader(http.StatusForbidden)
+		case "/restored", "/public":
+			fmt.Fprint(w, `back again`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	raw := "url " + srv.URL + "\ntype testHTTP\nquiet true\nexclude \"/removed\"\n" +
+		"expectMissing \"/removed\"\nexpectMissing \"/restored\"\nexpect \"/admin\" status 403\nexpect \"/public\" status 403\n"
+	ctx, err := runCrestfile(t, raw)
+	if err == nil {
+		t.Fatalf("expected pages that came back to fail the crawl")
+	}
+	var failures []string
+	for _, failure := range ctx.failures {
+		failures = append(failures, fmt.Sprintf("%s %s", failure.link.path, failure.message()))
+	}
+	expected := []string{"/restored OK, expected 404 or 410", "/public OK, expected 403"}
+	if !slices.Equal(failures, expected) {
+		t.Errorf("expected failures %q, got %q", expected, failures)
+	}
+	if visited := ctx.visited.Links(); !slices.Equal(visited, []string{"/", "/admin", "/public", "/removed", "/restored"}) {
+		t.Errorf("unexpected visited links %v", visited)
+	}
+}
+
+// Assertions check page content with CURRENT and CONTENT bound to each page.
+func TestContentAssertions(t *testing.T) {
+	srv := newTestSite(map[string]string{
+		"/":       `<a href="/docs/a">a</a><a href="/docs/b">b</a><footer>Made with crest</footer>`,
+		"/docs/a": `<link rel="canonical" href="/docs/a"><p>TODO</p><footer>Made with crest</footer>`,
+		"/docs/b": `<p>Lorem Ipsum</p>`,
+	})
+	defer srv.Close()
+
+	raw := `footer = "Made with crest"
+page = CURRENT
+url ` + srv.URL + `
+type testHTTP
+quiet true
+assert "/docs/*" CONTENT contains {footer}
+assert "*" CONTENT excludes "TODO"
+assert "*" CONTENT notMatches "(?i)lorem ipsum"
+assert "*" CURRENT matches "^/[a-z/]*$"
+assert "/docs/*" CONTENT contains {page}
+`
+	ctx, err := runCrestfile(t, raw)
+	if err == nil {
+		t.Fatalf("expected failed assertions to fail the crawl")
+	}
+	var failures []string
+	for _, failure := range ctx.failures {
+		failures = append(failures, fmt.Sprintf("%s %s: %s", failure.check, failure.link.path, failure.err))
+	}
+	expected := []string{
+		`assert /docs/a: CONTENT contains "TODO"`,
+		`assert /docs/b: CONTENT does not contain "Made with crest"`,
+		`assert /docs/b: CONTENT matches "(?i)lorem ipsum"`,
+		`assert /docs/b: CONTENT does not contain CURRENT`,
+	}
+	if !slices.Equal(failures, expected) {
+		t.Errorf("expected failures %q, got %q", expected, failures)
+	}
+	if len(ctx.CURRENT) > 0 || len(ctx.CONTENT) > 0 {
+		t.Errorf("CURRENT and CONTENT should only be bound while a page is checked")
+	}
+
+	if _, err := newAssertion("*", "BODY", "contains", "x"); err == nil {
+		t.Errorf("expected an unknown subject to be rejected")
+	}
+	if _, err := newAssertion("*", "CONTENT", "matches", "("); err == nil {
+		t.Errorf("expected an invalid pattern to be rejected")
+	}
+}
+
+// CSS selectors match like they do in a browser, for the subset crest supports.
+func TestSelectors(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<div id="main" class="a b"><p class="x">t</p><section><p data-k="v-1">u</p></section></div><p>z</p>`))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	counts := map[string]int{
+		"p":                         3,
+		"div p":                     2,
+		"div > p":                   1,
+		"div>section>p":             1,
+		"#main .x":                  1,
+		"div.a.b":                   1,
+		"div.a.c":                   0,
+		"p[data-k]":                 1,
+		"p[data-k^=v]":              1,
+		"p[data-k$=\"-1\"]":         1,
+		"p[data-k='v-1'], .x":       2,
+		"section *":                 1,
+		"body > div > section > p ": 1,
+	}
+	for raw, count := range counts {
+		selector, err := parseSelector(raw)
+		if err != nil {
+			t.Errorf("%q: %v", raw, err)
+			continue
+		}
+		if found := len(selector.QueryAll(doc)); found != count {
+			t.Errorf("%q: expected %d elements, found %d", raw, count, found)
+		}
+	}
+	for _, raw := range []string{"p:first-child", "div >", "[x", "p[x=]", "", "a ~ b"} {
+		if _, err := parseSelector(raw); err == nil {
+			t.Errorf("%q: expected an error", raw)
+		}
+	}
+
+	srv := newTestSite(map[string]string{
+		"/":         `<html lang="en"><a href="/blog/one">1</a><a href="/blog/two">2</a></html>`,
+		"/blog/one": `<html lang="en"><h1>One</h1><article><time datetime="2024-01-01">Jan</time></article></html>`,
+		"/blog/two": `<html lang="fr"><h1>two</h1><h1>Again</h1><article><time>Jan</time></article><marquee>hi</marquee></html>`,
+	})
+	defer srv.Close()
+
+	raw := "url " + srv.URL + `
+type testHTTP
+quiet true
+select "/blog/*" "h1" count 1
+select "/blog/*" "article time[datetime]" exists true
+select "*" "html" attr "lang=en"
+select "/blog/*" "h1" text "^[A-Z]"
+select "*" "marquee" exists false
+`
+	ctx, err := runCrestfile(t, raw)
+	if err == nil {
+		t.Fatalf("expected failed selections to fail the crawl")
+	}
+	var failures []string
+	for _, failure := range ctx.failures {
+		failures = append(failures, fmt.Sprintf("%s %s: %s", failure.check, failure.link.path, failure.err))
+	}
+	expected := []string{
+		`select /blog/two: expected 1 elements matching "h1", found 2`,
+		`select /blog/two: no element matches "article time[datetime]"`,
+		`select /blog/two: <html> matching "html" has lang="fr", expected "en"`,
+		`select /blog/two: text "two" of <h1> matching "h1" does not match "^[A-Z]"`,
+		`select /blog/two: 1 elements match "marquee"`,
+	}
+	if !slices.Equal(failures, expected) {
+		t.Errorf("expected failures %q, got %q", expected, failures)
+	}
+}
+
+// testHTML reports validity problems with the line and column of the source.
+func TestHtmlValidity(t *testing.T) {
+	source := `<html>
+<head><title>t</title></head>
+<body>
+<div id="a"><p id="a">x</p></div>
+<b><i>bold</b></i>
+<p>héllo wörld ✓</p><center>old</center>
+<a href="/x">one <a href="/y">two</a></a>
+<img src="x.png" width="wide" loading="soon">
+<section>
+<div/>
+<svg><path d="M0"/></svg>
+</body>
+</html>
+`
+	var problems []string
+	for _, problem := range checkHtml(Link{path: "/"}, []byte(source)) {
+		problems = append(problems, fmt.Sprintf("%d:%d %s", problem.line, problem.col, problem.err))
+	}
+	expected := []string{
+		`1:1 missing <!DOCTYPE html>`,
+		`1:1 <html> is missing a lang attribute`,
+		`4:13 duplicate id "a", first used at 4:1`,
+		`5:11 </b> found while <i> is still open, the tags are misnested or <i> is never closed`,
+		`5:15 </i> has no matching <i>`,
+		`6:21 <center> is obsolete`,
+		`7:18 <a> nested inside another <a>`,
+		`8:1 width="wide" of <img> must be a non-negative integer`,
+		`8:1 loading="soon" is not a valid value for <img>`,
+		`10:1 <div/> does not close a <div>, only void elements can be self-closing`,
+		`12:1 </body> found while <section> is still open, the tags are misnested or <section> is never closed`,
+	}
+	if !slices.Equal(problems, expected) {
+		t.Errorf("expected problems\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(problems, "\n"))
+	}
+
+	srv := newTestSite(map[string]string{
+		"/":      "<!DOCTYPE html>\n<html lang=\"en\"><title>ok</title><p>fine<ul><li>a<li>b</ul><a href=\"/table\">table</a></html>",
+		"/table": "<!DOCTYPE html>\n<html lang=\"en\"><table><tr><td colspan=\"two\">x</table></html>",
+	})
+	defer srv.Close()
+	ctx := Context{quiet: true}
+	if err := Handle([]string{"crest", "--test-html", srv.URL}, &ctx); err == nil {
+		t.Fatalf("expected invalid HTML to fail the crawl")
+	}
+	if len(ctx.failures) != 1 || ctx.failures[0].check != "html" || ctx.failures[0].link.path != "/table" || ctx.failures[0].line != 2 || ctx.failures[0].col != 28 {
+		t.Errorf("unexpected failures %+v", ctx.failures)
+	}
+}
+
+// Accessibility problems are reported with their rule, unless suppressed for the path.
+func TestAccessibility(t *testing.T) {
+	srv := newTestSite(map[string]string{
+		"/": `<html lang="en"><title>home</title>
+<a href="/form">form</a><a href="/legacy/table"><img src="/logo.png" alt="legacy"></a>
+<img src="/deco.png" alt=""><img src="/chart.png">
+<a href="/form"></a><button></button><button aria-label="close">x</button>
+<h1>a</h1><h3>c</h3><h2>b</h2>
+</html>`,
+		"/form": `<html><form><label for="name">Name</label><input id="name"><label>Mail <input type="email"></label>
+<input type="text"><select><option>a</option></select><input type="submit"><input type="image" src="/go.png"></form>
+<table><tr><td>1</td></tr></table><table><tr><th>h</th></tr></table></html>`,
+		"/legacy/table": `<html lang="en"><title>legacy</title><table><tr><td>1</td></tr></table></html>`,
+	})
+	defer srv.Close()
+
+	raw := `url ` + srv.URL + `
+type testA11y
+quiet true
+assets false
+suppress "/legacy/*" "table-header"
+`
+	ctx, err := runCrestfile(t, raw)
+	if err == nil {
+		t.Fatalf("expected accessibility problems to fail the crawl")
+	}
+	var failures []string
+	for _, failure := range ctx.failures {
+		failures = append(failures, fmt.Sprintf("%s %s %s", failure.check, failure.link.path, failure.message()))
+	}
+	expected := []string{
+		`a11y / img-alt: <img src="/chart.png"> has no alt attribute`,
+		`a11y / link-name: <a href="/form"> has no text`,
+		`a11y / button-name: <button> has no text`,
+		`a11y / heading-order: <h3> follows <h1>, skipping a level`,
+		`a11y /form html-lang: <html> has no lang attribute`,
+		`a11y /form input-label: <input type="text"> has no label`,
+		`a11y /form input-label: <select> has no label`,
+		`a11y /form img-alt: <input type="image" src="/go.png"> has no alt attribute`,
+		`a11y /form table-header: <table> has no <th> cells`,
+		`a11y /form document-title: the page has no <title>`,
+	}
+	if !slices.Equal(failures, expected) {
+		t.Errorf("expected failures\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(failures, "\n"))
+	}
+
+	raw = `url ` + srv.URL + `
+type testA11y
+quiet true
+suppress "*" "no-such-rule"
+`
+	if _, err := runCrestfile(t, raw); err == nil || !strings.Contains(err.Error(), UNKNOWN_RULE) {
+		t.Errorf("expected an unknown rule error, got %v", err)
+	}
+}
+
+// Page metadata is checked on every page and compared across the site.
+func TestSeo(t *testing.T) {
+	page := func(head string, body string) string {
+		return `<!DOCTYPE html><html lang="en"><head>` + head + `</head><body>` + body + `</body></html>`
+	}
+	srv := newTestSite(map[string]string{
+		"/sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/</loc></url>
+  <url><loc>https://example.com/a</loc></url>
+  <url><loc>https://example.com/b</loc></url>
+  <url><loc>https://example.com/alias</loc></url>
+  <url><loc>https://example.com/hidden</loc></url>
+  <url><loc>https://example.com/bad</loc></url>
+</urlset>`,
+		"/": page(`<title>Home of crest</title><meta name="description" content="The home page of the site.">
+<link rel="canonical" href="https://example.com/">`, `<h1>Home</h1><a href="/a">a</a>`),
+		"/a": page(`<title>Page about A</title><meta name="description" content="Everything about A.">
+<link rel="canonical" href="/a">`, `<h1>A</h1>`),
+		"/b": page(`<title>Page about A</title><meta name="description" content="Everything about B.">
+<link rel="canonical" href="b">`, `<h1>B</h1>`),
+		"/alias": page(`<title>Page about A</title><meta name="description" content="Everything about A.">
+<link rel="canonical" href="/a">`, `<h1>A</h1>`),
+		"/hidden": page(`<title>Hidden page</title><meta name="description" content="Nobody should find this.">
+<meta name="robots" content="noindex, nofollow"><link rel="canonical" href="/hidden">`, `<h1>Hidden</h1>`),
+		"/bad": page(`<title>Hi</title><link rel="canonical" href="/gone">`, `<h1>One</h1><h1>Two</h1>`),
+	})
+	defer srv.Close()
+
+	ctx := Context{quiet: true}
+	if err := Handle([]string{"crest", "--test-seo", "--sitemap", "--title-length", "5-30", "--description-length", "10-80", srv.URL}, &ctx); err == nil {
+		t.Fatalf("expected SEO problems to fail the crawl")
+	}
+	var failures []string
+	for _, failure := range ctx.failures {
+		failures = append(failures, fmt.Sprintf("%s %s %s", failure.check, failure.link.path, failure.err))
+	}
+	expected := []string{
+		`seo /bad the title is 2 characters long, expected 5 to 30`,
+		`seo /bad the page has no meta description`,
+		`seo /bad the page has 2 <h1> elements, expected 1`,
+		`http /gone STATUS ERROR! in ` + srv.URL + `/gone | STATUS: 404`,
+		`seo /b the title "Page about A" is also used by /a`,
+		`seo /hidden the page is noindex but listed in /sitemap.xml`,
+	}
+	if !slices.Equal(failures, expected) {
+		t.Errorf("expected failures\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(failures, "\n"))
+	}
+
+	// Without --sitemap, the sitemap is only read to find noindex pages.
+	srv = newTestSite(map[string]string{
+		"/sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/</loc></url>
+  <url><loc>https://example.com/hidden</loc></url>
+  <url><loc>https://example.com/unlinked</loc></url>
+</urlset>`,
+		"/": page(`<title>Home of crest</title><meta name="description" content="The home page of the site.">
+<link rel="canonical" href="/">`, `<h1>Home</h1><a href="/hidden">hidden</a><a href="/orphan">orphan</a>`),
+		"/hidden": page(`<title>Hidden page</title><meta name="description" content="Nobody should find this.">
+<meta name="robots" content="noindex"><link rel="canonical" href="/hidden">`, `<h1>Hidden</h1>`),
+		"/orphan": page(`<title>Orphan page</title><meta name="description" content="Not in the sitemap.">
+<link rel="canonical" href="/orphan">`, `<h1>Orphan</h1>`),
+		"/unlinked": page(`<title>Unlinked</title>`, ``),
+	})
+	defer srv.Close()
+	ctx = Context{quiet: true}
+	if err := Handle([]string{"crest", "--test-seo", "--title-length", "5-30", "--description-length", "10-80", srv.URL}, &ctx); err == nil {
+		t.Fatalf("expected the noindex page in the sitemap to fail the crawl")
+	}
+	if visited := ctx.visited.Links(); !slices.Equal(visited, []string{"/", "/hidden", "/orphan"}) {
+		t.Errorf("expected the sitemap not to seed the crawl, visited %v", visited)
+	}
+	if len(ctx.failures) != 1 || ctx.failures[0].link.path != "/hidden" || ctx.failures[0].check != "seo" {
+		t.Errorf("unexpected failures %+v", ctx.failures)
+	}
+
+	// A site without a sitemap passes.
+	srv = newTestSite(map[string]string{
+		"/": page(`<title>Home of crest</title><meta name="description" content="The home page of the site.">
+<link rel="canonical" href="/">`, `<h1>Home</h1>`),
+	})
+	defer srv.Close()
+	ctx = Context{quiet: true}
+	if err := Handle([]string{"crest", "--test-seo", "--title-length", "5-30", "--description-length", "10-80", srv.URL}, &ctx); err != nil {
+		t.Errorf("expected a site without a sitemap to pass, got %v %+v", err, ctx.failures)
+	}
+
+	for _, raw := range []string{"60", "a-b", "60-10", "0-0"} {
+		if _, err := parseLengthRange(raw); err == nil {
+			t.Errorf("expected %q to be an invalid length range", raw)
+		}
+	}
+}
+
 // Test crestfile parser/ir-compiler.
 func TestParse(t *testing.T) {
 	var s State
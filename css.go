@@ -0,0 +1,101 @@
+package main
+
+import (
+	"mime"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssUrl     = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s]*))\s*\)`)
+	cssImport  = regexp.MustCompile(`(?i)@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+func isCss(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/css"
+}
+
+func parseCssRefs(css string) []string {
+	/*
+	 * Collect every url(...) and every @import "..." of a
+	 * stylesheet in the order they appear. @import url(...)
+	 * is already covered by the url(...) pattern.
+	 */
+	css = cssComment.ReplaceAllString(css, "")
+	type match struct {
+		offset int
+		ref    string
+	}
+	var matches []match
+	for _, pattern := range []*regexp.Regexp{cssUrl, cssImport} {
+		for _, m := range pattern.FindAllStringSubmatchIndex(css, -1) {
+			for group := 1; group < len(m)/2; group++ {
+				if m[2*group] >= 0 {
+					matches = append(matches, match{offset: m[0], ref: css[m[2*group]:m[2*group+1]]})
+					break
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].offset < matches[j].offset
+	})
+
+	var refs []string
+	for _, m := range matches {
+		if ref := strings.TrimSpace(m.ref); len(ref) > 0 {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// getCssLinks returns the assets referenced by a stylesheet, resolved against base.
+func getCssLinks(css string, base *url.URL, pageUrl *url.URL) []Link {
+	var links []Link
+	for _, ref := range parseCssRefs(css) {
+		if path, _, ok := resolveLink(base, pageUrl, ref); ok {
+			links = append(links, Link{path: path, text: "css url(" + ref + ")", asset: true})
+		}
+	}
+	return links
+}
+
+func getInlineCssLinks(n *html.Node, pageUrl *url.URL) []Link {
+	/*
+	 * Inline stylesheets live in the document, so their
+	 * references resolve against the document base URL.
+	 */
+	var links []Link
+	base := getBaseUrl(n, pageUrl)
+	for c := range n.Descendants() {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if c.Data == "style" {
+			var css strings.Builder
+			for t := range c.Descendants() {
+				if t.Type == html.TextNode {
+					css.WriteString(t.Data)
+				}
+			}
+			links = append(links, getCssLinks(css.String(), base, pageUrl)...)
+		}
+		for _, attr := range c.Attr {
+			if attr.Key == "style" {
+				links = append(links, getCssLinks(attr.Val, base, pageUrl)...)
+			}
+		}
+	}
+	return links
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Host used for the URLs of pages crawled straight from a directory.
+const DIR_HOST = "http://localhost"
+
+/*
+ * DirTransport answers HTTP requests from a file system
+ * the way a typical static host would:
+ *
+ *   /               -> index.html
+ *   /docs/          -> docs/index.html
+ *   /docs           -> docs, docs.html, or a redirect to /docs/
+ *   /docs/page.html -> docs/page.html
+ *
+ * Anything else is a 404.
+ */
+type DirTransport struct {
+	fsys fs.FS
+}
+
+func NewDirTransport(fsys fs.FS) *DirTransport {
+	return &DirTransport{fsys: fsys}
+}
+
+func (t *DirTransport) isFile(name string) bool {
+	info, err := fs.Stat(t.fsys, name)
+	return err == nil && !info.IsDir()
+}
+
+// resolve returns the file serving urlPath, or whether it should redirect to urlPath + "/".
+func (t *DirTransport) resolve(urlPath string) (string, bool, bool) {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if len(name) == 0 {
+		name = "."
+	}
+
+	if strings.HasSuffix(urlPath, "/") {
+		index := path.Join(name, "index.html")
+		return index, false, t.isFile(index)
+	}
+	if t.isFile(name) {
+		return name, false, true
+	}
+	if t.isFile(name + ".html") {
+		return name + ".html", false, true
+	}
+	if t.isFile(path.Join(name, "index.html")) {
+		return "", true, true
+	}
+	return "", false, false
+}
+
+func (t *DirTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+
+	name, redirect, ok := t.resolve(req.URL.Path)
+	if redirect {
+		location := *req.URL
+		location.Path += "/"
+		location.RawPath = ""
+		res.StatusCode = http.StatusMovedPermanently
+		res.Status = "301 Moved Permanently"
+		res.Header.Set("Location", location.String())
+		res.Body = io.NopCloser(strings.NewReader(""))
+		return res, nil
+	}
+	if !ok {
+		res.StatusCode = http.StatusNotFound
+		res.Status = "404 Not Found"
+		res.Body = io.NopCloser(strings.NewReader(""))
+		return res, nil
+	}
+	content, err := fs.ReadFile(t.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if len(contentType) == 0 {
+		contentType = http.DetectContentType(content)
+	}
+	res.StatusCode = http.StatusOK
+	res.Status = "200 OK"
+	res.Header.Set("Content-Type", contentType)
+	res.ContentLength = int64(len(content))
+	res.Body = io.NopCloser(bytes.NewReader(content))
+	return res, nil
+}
+
+/*
+ * NewFileServer serves root with the URL rules of
+ * DirTransport, so crest serve and crest crawl --dir find
+ * the same pages. Directories without an index.html are
+ * not listed, they are a 404 like any missing page.
+ */
+func NewFileServer(root string) http.Handler {
+	fsys := os.DirFS(root)
+	transport := NewDirTransport(fsys)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, redirect, ok := transport.resolve(r.URL.Path)
+		if redirect {
+			location := *r.URL
+			location.Path += "/"
+			location.RawPath = ""
+			http.Redirect(w, r, location.String(), http.StatusMovedPermanently)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(content))
+	})
+}
+
+// filePages returns every URL path that serves the file name, canonical first.
+func filePages(name string) []string {
+	urlPath := "/" + name
+	if path.Base(name) == "index.html" {
+		dir := strings.TrimSuffix(urlPath, "index.html")
+		pages := []string{dir, urlPath}
+		if dir != "/" {
+			pages = append(pages, strings.TrimSuffix(dir, "/"))
+		}
+		return pages
+	}
+	if strings.HasSuffix(name, ".html") {
+		return []string{urlPath, strings.TrimSuffix(urlPath, ".html")}
+	}
+	return []string{urlPath}
+}
+
+func (c *Context) checkOrphanFiles() error {
+	/*
+	 * Report the HTML files under ctx.root that the crawl
+	 * never reached under any of their URLs. These are
+	 * usually stale pages a generator left behind. Files
+	 * can be ignored by excluding one of their URLs.
+	 */
+	reached := make(map[string]bool)
+	for _, page := range c.pages {
+		if page.err != nil {
+			continue
+		}
+		pagePath, _, _ := strings.Cut(page.link.path, "?")
+		if unescaped, err := url.PathUnescape(pagePath); err == nil {
+			pagePath = unescaped
+		}
+		reached[pagePath] = true
+	}
+
+	return fs.WalkDir(os.DirFS(c.root), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || (path.Ext(name) != ".html" && path.Ext(name) != ".htm") {
+			return nil
+		}
+		pages := filePages(name)
+		if len(c.computeExcludedLinks(pages)) < len(pages) {
+			return nil
+		}
+		for _, page := range pages {
+			if reached[page] {
+				return nil
+			}
+		}
+		c.printv(os.Stderr, fmt.Sprintf("Orphan file %s", name), fmt.Sprintf("%s is not linked from any page, it would be served at %s", name, pages[0]))
+		c.failures = append(c.failures, LinkFailure{link: Link{path: pages[0]}, check: "orphan", err: errors.New(fmt.Sprintf("%s is not linked from any page", name))})
+		return nil
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+ * A path pattern from a Crestfile, where * matches any
+ * run of characters. Patterns are compiled once, when the
+ * Crestfile is read, since they are matched against every
+ * page of the crawl.
+ */
+type PathPattern struct {
+	raw  string
+	expr *regexp.Regexp
+}
+
+func newPathPattern(raw string) PathPattern {
+	pattern := PathPattern{raw: raw}
+	if strings.Contains(raw, "*") {
+		pattern.expr = regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(raw), `\*`, ".*") + "$")
+	}
+	return pattern
+}
+
+// Match reports whether path matches the pattern.
+func (p PathPattern) Match(path string) bool {
+	if p.expr == nil {
+		return p.raw == path
+	}
+	return p.expr.MatchString(path)
+}
+
+/*
+ * Statuses a path is expected to answer with. Paths are
+ * matched as PathPatterns, and when several expectations
+ * match a path the one with the longest pattern wins.
+ */
+type Expectation struct {
+	pattern  PathPattern
+	statuses []int
+}
+
+func parseStatus(raw string) (int, error) {
+	status, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || status < 100 || status > 599 {
+		return 0, errors.New(INVALID_STATUS)
+	}
+	return status, nil
+}
+
+// parseStatuses reads a comma separated list of status codes such as "204,410".
+func parseStatuses(raw string) ([]int, error) {
+	var statuses []int
+	for _, code := range strings.Split(raw, ",") {
+		status, err := parseStatus(code)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func formatStatuses(statuses []int) string {
+	codes := []string{}
+	for _, status := range statuses {
+		codes = append(codes, strconv.Itoa(status))
+	}
+	return strings.Join(codes, " or ")
+}
+
+func (c *Context) expectStatus(pattern string, statuses ...int) error {
+	// Paths without * are requested, so they must be absolute.
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "*") {
+		return errors.New(fmt.Sprintf("%s %q", INVALID_EXPECT_PATH, pattern))
+	}
+	for i := range c.expectations {
+		if c.expectations[i].pattern.raw == pattern {
+			c.expectations[i].statuses = append(c.expectations[i].statuses, statuses...)
+			return nil
+		}
+	}
+	c.expectations = append(c.expectations, Expectation{pattern: newPathPattern(pattern), statuses: statuses})
+	return nil
+}
+
+// expectedStatuses returns the statuses declared for path, if any.
+func (c *Context) expectedStatuses(path string) []int {
+	var statuses []int
+	longest := -1
+	for _, expectation := range c.expectations {
+		if len(expectation.pattern.raw) > longest && expectation.pattern.Match(path) {
+			statuses = expectation.statuses
+			longest = len(expectation.pattern.raw)
+		}
+	}
+	return statuses
+}
+
+/*
+ * acceptsStatus reports whether a response to path passes.
+ * Declared expectations replace the default completely,
+ * so `expect "/gone" status 410` also fails a 200. Other
+ * paths pass with 200 or any of the allowed statuses.
+ */
+func (c *Context) acceptsStatus(path string, status int) bool {
+	if expected := c.expectedStatuses(path); expected != nil {
+		return slices.Contains(expected, status)
+	}
+	return status == http.StatusOK || slices.Contains(c.allowStatus, status)
+}
+
+func (c *Context) checkExpectedPaths(host string) error {
+	/*
+	 * Request every path with an expectation that the crawl
+	 * did not reach, so removed or protected pages are
+	 * checked even when nothing links to them anymore.
+	 * They are requested regardless of exclude and robots.txt,
+	 * and never crawled. Patterns with * cannot be requested.
+	 */
+	links := []Link{}
+	for _, expectation := range c.expectations {
+		if expectation.pattern.expr != nil {
+			continue
+		}
+		if c.visited.Add(expectation.pattern.raw) {
+			links = append(links, Link{path: expectation.pattern.raw})
+		}
+	}
+	if len(links) == 0 {
+		return nil
+	}
+
+	for _, result := range crawlLevel(host, links, 0, c) {
+		c.pages = append(c.pages, result)
+		if result.err != nil {
+			c.printv(os.Stderr, fmt.Sprintf("Unexpected status for %s", result.link.path), result.err.Error())
+			c.failures = append(c.failures, result.failure())
+			if c.failFast {
+				return result.err
+			}
+			continue
+		}
+		c.printv(os.Stdout, "Expectation met", fmt.Sprintf("%s answered with the expected %d", result.link.path, result.status))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// getPageAnchors returns every id and name a fragment of the page can point at.
+func getPageAnchors(n *html.Node) map[string]bool {
+	anchors := make(map[string]bool)
+	for c := range n.Descendants() {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		for _, attr := range c.Attr {
+			if attr.Key == "id" || (attr.Key == "name" && c.Data == "a") {
+				anchors[attr.Val] = true
+			}
+		}
+	}
+	return anchors
+}
+
+func (c *Context) recordFragments(result PageResult) {
+	/*
+	 * Remember the anchors of a checked page and every
+	 * fragment link it declares. The links are checked
+	 * once the crawl is done and every page is known.
+	 */
+	if c.anchors == nil {
+		c.anchors = make(map[string]map[string]bool)
+	}
+	if result.anchors != nil {
+		c.anchors[result.link.path] = result.anchors
+	}
+	for _, link := range result.links {
+		if !link.asset && len(link.fragment) > 0 {
+			c.fragments = append(c.fragments, link)
+		}
+	}
+}
+
+func (c *Context) checkFragmentLinks() {
+	/*
+	 * Fragments pointing at pages that were not parsed
+	 * (excluded, broken or not HTML) are skipped. Empty
+	 * and "top" fragments always scroll to the top of the
+	 * page, and text fragments are not ids at all.
+	 */
+	seen := make(map[string]bool)
+	for _, link := range c.fragments {
+		key := link.referrer + " " + link.path + "#" + link.fragment
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		anchors, parsed := c.anchors[link.path]
+		if !parsed || strings.EqualFold(link.fragment, "top") || strings.HasPrefix(link.fragment, ":~:") {
+			continue
+		}
+		if !anchors[link.fragment] {
+			err := errors.New(fmt.Sprintf("no element with id %q in %s", link.fragment, link.path))
+			c.printv(os.Stderr, fmt.Sprintf("Broken fragment %s#%s", link.path, link.fragment), err.Error())
+			c.failures = append(c.failures, LinkFailure{link: link, check: "fragment", err: err})
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Elements that never have content or an end tag.
+var VOID_ELEMENTS = []string{"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr"}
+
+// Elements whose end tag may be left out.
+var OPTIONAL_END_ELEMENTS = []string{"html", "head", "body", "p", "li", "dt", "dd", "option", "optgroup", "tr", "td", "th", "thead", "tbody", "tfoot", "colgroup", "caption", "rt", "rp"}
+
+// Elements removed from HTML, see https://html.spec.whatwg.org/#non-conforming-features
+var OBSOLETE_ELEMENTS = []string{"acronym", "applet", "basefont", "bgsound", "big", "blink", "center", "dir", "font", "frame", "frameset", "isindex", "keygen", "listing", "marquee", "menuitem", "multicol", "nextid", "nobr", "noembed", "noframes", "plaintext", "rb", "rtc", "spacer", "strike", "tt", "xmp"}
+
+// Enumerated attributes and the values they accept, "*" standing for any element.
+var ENUMERATED_ATTRIBUTES = map[string][]string{
+	"*/dir":             {"ltr", "rtl", "auto"},
+	"*/contenteditable": {"", "true", "false", "plaintext-only"},
+	"*/draggable":       {"true", "false"},
+	"*/spellcheck":      {"", "true", "false"},
+	"*/translate":       {"", "yes", "no"},
+	"button/type":       {"submit", "reset", "button"},
+	"form/method":       {"get", "post", "dialog"},
+	"form/enctype":      {"application/x-www-form-urlencoded", "multipart/form-data", "text/plain"},
+	"img/loading":       {"lazy", "eager"},
+	"img/decoding":      {"sync", "async", "auto"},
+	"iframe/loading":    {"lazy", "eager"},
+	"input/type":        {"hidden", "text", "search", "tel", "url", "email", "password", "date", "month", "week", "time", "datetime-local", "number", "range", "color", "checkbox", "radio", "file", "submit", "image", "reset", "button"},
+	"th/scope":          {"row", "col", "rowgroup", "colgroup"},
+	"track/kind":        {"subtitles", "captions", "descriptions", "chapters", "metadata"},
+}
+
+// Attributes that must be non-negative integers.
+var INTEGER_ATTRIBUTES = []string{"img/width", "img/height", "canvas/width", "canvas/height", "video/width", "video/height", "iframe/width", "iframe/height", "td/colspan", "td/rowspan", "th/colspan", "th/rowspan", "textarea/rows", "textarea/cols", "input/size", "input/maxlength", "input/minlength", "select/size", "ol/start"}
+
+/*
+ * htmlChecker walks the tokens of a page keeping its own
+ * stack of open elements. html.Parse silently repairs the
+ * mistakes this is looking for, which is why the parsed
+ * tree can not be used.
+ */
+type htmlChecker struct {
+	problems []LinkFailure
+	link     Link
+	line     int
+	col      int
+	stack    []string
+	ids      map[string][2]int
+	root     bool
+}
+
+func (h *htmlChecker) report(line int, col int, format string, args ...any) {
+	h.problems = append(h.problems, LinkFailure{link: h.link, check: "html", line: line, col: col, err: errors.New(fmt.Sprintf(format, args...))})
+}
+
+// advance moves the position past the raw bytes of a token, counting columns in characters.
+func (h *htmlChecker) advance(raw []byte) {
+	for len(raw) > 0 {
+		c, size := utf8.DecodeRune(raw)
+		raw = raw[size:]
+		if c == '\n' {
+			h.line++
+			h.col = 1
+		} else {
+			h.col++
+		}
+	}
+}
+
+func (h *htmlChecker) foreign() bool {
+	return slices.Contains(h.stack, "svg") || slices.Contains(h.stack, "math")
+}
+
+func (h *htmlChecker) checkAttributes(tag string, attrs []html.Attribute, line int, col int) {
+	seen := make(map[string]bool)
+	for _, attr := range attrs {
+		if seen[attr.Key] {
+			h.report(line, col, "<%s> has the %s attribute more than once", tag, attr.Key)
+		}
+		seen[attr.Key] = true
+
+		if attr.Key == "id" {
+			if len(attr.Val) == 0 || strings.ContainsAny(attr.Val, " \t\n\f\r") {
+				h.report(line, col, "id %q of <%s> must not be empty or contain whitespace", attr.Val, tag)
+			} else if first, ok := h.ids[attr.Val]; ok {
+				h.report(line, col, "duplicate id %q, first used at %d:%d", attr.Val, first[0], first[1])
+			} else {
+				h.ids[attr.Val] = [2]int{line, col}
+			}
+		}
+
+		values, ok := ENUMERATED_ATTRIBUTES[tag+"/"+attr.Key]
+		if !ok {
+			values, ok = ENUMERATED_ATTRIBUTES["*/"+attr.Key]
+		}
+		if ok && !slices.Contains(values, strings.ToLower(attr.Val)) {
+			h.report(line, col, "%s=%q is not a valid value for <%s>", attr.Key, attr.Val, tag)
+		}
+		if slices.Contains(INTEGER_ATTRIBUTES, tag+"/"+attr.Key) {
+			if n, err := strconv.Atoi(strings.TrimSpace(attr.Val)); err != nil || n < 0 {
+				h.report(line, col, "%s=%q of <%s> must be a non-negative integer", attr.Key, attr.Val, tag)
+			}
+		}
+	}
+}
+
+func (h *htmlChecker) startTag(tag string, attrs []html.Attribute, selfClosing bool, line int, col int) {
+	if tag == "html" {
+		h.root = true
+		lang := ""
+		for _, attr := range attrs {
+			if attr.Key == "lang" {
+				lang = attr.Val
+				break
+			}
+		}
+		if len(strings.TrimSpace(lang)) == 0 {
+			h.report(line, col, "<html> is missing a lang attribute")
+		}
+	}
+	if slices.Contains(OBSOLETE_ELEMENTS, tag) {
+		h.report(line, col, "<%s> is obsolete", tag)
+	}
+	if tag == "a" && slices.Contains(h.stack, "a") {
+		h.report(line, col, "<a> nested inside another <a>")
+	}
+	h.checkAttributes(tag, attrs, line, col)
+
+	if slices.Contains(VOID_ELEMENTS, tag) {
+		return
+	}
+	if selfClosing && !h.foreign() && tag != "svg" && tag != "math" {
+		h.report(line, col, "<%s/> does not close a <%s>, only void elements can be self-closing", tag, tag)
+	}
+	if selfClosing && (h.foreign() || tag == "svg" || tag == "math") {
+		return
+	}
+	h.stack = append(h.stack, tag)
+}
+
+func (h *htmlChecker) endTag(tag string, line int, col int) {
+	if slices.Contains(VOID_ELEMENTS, tag) {
+		if tag != "br" {
+			h.report(line, col, "</%s> closes a void element", tag)
+		}
+		return
+	}
+	index := -1
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		if h.stack[i] == tag {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		if !slices.Contains(OPTIONAL_END_ELEMENTS, tag) {
+			h.report(line, col, "</%s> has no matching <%s>", tag, tag)
+		}
+		return
+	}
+	// Elements with optional end tags are closed implicitly.
+	for _, open := range h.stack[index+1:] {
+		if !slices.Contains(OPTIONAL_END_ELEMENTS, open) && !h.foreign() {
+			h.report(line, col, "</%s> found while <%s> is still open, the tags are misnested or <%s> is never closed", tag, open, open)
+			break
+		}
+	}
+	h.stack = h.stack[:index]
+}
+
+// checkHtml reports the validity problems of a page source, with their line and column.
+func checkHtml(link Link, source []byte) []LinkFailure {
+	h := htmlChecker{link: link, line: 1, col: 1, ids: make(map[string][2]int)}
+	z := html.NewTokenizer(bytes.NewReader(source))
+	doctype := false
+	content := false
+	for {
+		tokenType := z.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		line, col := h.line, h.col
+		raw := z.Raw()
+		token := z.Token()
+		h.advance(raw)
+
+		switch tokenType {
+		case html.DoctypeToken:
+			if content {
+				h.report(line, col, "<!DOCTYPE> must come before any content")
+			} else if !strings.EqualFold(strings.TrimSpace(token.Data), "html") {
+				h.report(line, col, "<!DOCTYPE %s> is not the HTML doctype, use <!DOCTYPE html>", token.Data)
+			}
+			doctype = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if !doctype && !content {
+				h.report(line, col, "missing <!DOCTYPE html>")
+			}
+			content = true
+			h.startTag(token.Data, token.Attr, tokenType == html.SelfClosingTagToken, line, col)
+		case html.EndTagToken:
+			content = true
+			h.endTag(token.Data, line, col)
+		case html.TextToken:
+			if len(strings.TrimSpace(token.Data)) > 0 {
+				if !doctype && !content {
+					h.report(line, col, "missing <!DOCTYPE html>")
+				}
+				content = true
+			}
+		}
+	}
+	for _, open := range h.stack {
+		if !slices.Contains(OPTIONAL_END_ELEMENTS, open) {
+			h.report(h.line, h.col, "<%s> is never closed", open)
+		}
+	}
+	if !h.root {
+		h.report(1, 1, "missing <html lang> element")
+	}
+	return h.problems
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"slices"
+)
+
+type JunitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Details string `xml:",chardata"`
+}
+
+type JunitTestcase struct {
+	Classname string         `xml:"classname,attr"`
+	Name      string         `xml:"name,attr"`
+	Time      string         `xml:"time,attr"`
+	Failures  []JunitFailure `xml:"failure"`
+}
+
+type JunitTestsuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Testcases []JunitTestcase `xml:"testcase"`
+}
+
+type JunitTestsuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Testsuites []JunitTestsuite `xml:"testsuite"`
+}
+
+func (c *Context) enabledChecks() []string {
+	var checks []string
+	if c.hasTest("testRobots") {
+		checks = append(checks, "robots")
+	}
+	if len(c.tests) == 0 || slices.ContainsFunc(c.tests, func(test string) bool { return test != "testRobots" }) {
+		checks = append(checks, "http")
+		if c.checkFragments {
+			checks = append(checks, "fragment")
+		}
+		if c.hasTest("testHTML") {
+			checks = append(checks, "html")
+		}
+		if c.hasTest("testA11y") {
+			checks = append(checks, "a11y")
+		}
+		if c.hasTest("testSEO") {
+			checks = append(checks, "seo")
+		}
+		if c.sitemap {
+			checks = append(checks, "sitemap")
+		}
+		if c.orphans {
+			checks = append(checks, "orphan")
+		}
+		if len(c.assertions) > 0 {
+			checks = append(checks, "assert")
+		}
+		if len(c.selections) > 0 {
+			checks = append(checks, "select")
+		}
+	}
+	return checks
+}
+
+// failureSuite returns the test suite a failure belongs to.
+func failureSuite(failure LinkFailure) string {
+	if failure.check == "redirect" {
+		return "http"
+	}
+	return failure.check
+}
+
+// failurePage returns the page a failure should be fixed on.
+func failurePage(failure LinkFailure) string {
+	if failure.check == "fragment" {
+		return failure.link.referrer
+	}
+	return failure.link.path
+}
+
+func junitFailure(failure LinkFailure) JunitFailure {
+	message := failure.message()
+	if failure.check == "http" || failure.check == "fragment" || failure.check == "redirect" {
+		referrer := failure.link.referrer
+		if len(referrer) == 0 {
+			referrer = "the start of the crawl"
+		}
+		message = fmt.Sprintf("%s (status %d) linked from %s with anchor text %q", failureUrl(failure), failure.status, referrer, failure.link.text)
+	} else if failure.col > 0 {
+		message = fmt.Sprintf("%s:%d:%d: %s", failureUrl(failure), failure.line, failure.col, failure.message())
+	} else if failure.line > 0 {
+		message = fmt.Sprintf("%s:%d: %s", failureUrl(failure), failure.line, failure.message())
+	}
+	return JunitFailure{Message: message, Type: failure.check, Details: failure.err.Error()}
+}
+
+func (c *Context) buildJunitReport() JunitTestsuites {
+	/*
+	 * Every check gets its own test suite. The http suite has
+	 * a test case for every visited URL, the other suites have
+	 * one for every HTML page they ran on. Failures are added
+	 * to the test case of the page they have to be fixed on,
+	 * redirect failures to the http test case of their URL.
+	 */
+	suites := JunitTestsuites{Name: "crest"}
+	for _, check := range c.enabledChecks() {
+		suite := JunitTestsuite{Name: check}
+		index := make(map[string]int)
+		addCase := func(path string, seconds float64) {
+			if _, ok := index[path]; ok {
+				return
+			}
+			index[path] = len(suite.Testcases)
+			suite.Testcases = append(suite.Testcases, JunitTestcase{
+				Classname: "crest." + check,
+				Name:      c.host + path,
+				Time:      fmt.Sprintf("%.3f", seconds),
+			})
+		}
+
+		if check == "robots" {
+			addCase("/robots.txt", 0)
+		}
+		for _, page := range c.pages {
+			if check == "robots" {
+				continue
+			} else if check == "http" {
+				addCase(page.link.path, page.elapsed.Seconds())
+			} else if page.err == nil && !page.link.asset && isHtml(page.contentType) {
+				addCase(page.link.path, 0)
+			}
+		}
+		for _, failure := range c.failures {
+			if failureSuite(failure) != check {
+				continue
+			}
+			addCase(failurePage(failure), 0)
+			testcase := &suite.Testcases[index[failurePage(failure)]]
+			if len(testcase.Failures) == 0 {
+				suite.Failures++
+			}
+			testcase.Failures = append(testcase.Failures, junitFailure(failure))
+		}
+
+		suite.Tests = len(suite.Testcases)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Testsuites = append(suites.Testsuites, suite)
+	}
+	return suites
+}
+
+func writeJunitReport(report JunitTestsuites, path string) error {
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), data...)
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
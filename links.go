@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+/*
+ * A LinkExtractor describes an element attribute that
+ * references another resource. Pages are crawled for
+ * more links, assets are fetched but never crawled.
+ */
+type LinkExtractor struct {
+	element   string
+	attribute string
+	asset     bool
+}
+
+var DEFAULT_EXTRACTORS = []LinkExtractor{
+	{element: "a", attribute: "href"},
+	{element: "area", attribute: "href"},
+	{element: "img", attribute: "src", asset: true},
+	{element: "img", attribute: "srcset", asset: true},
+	{element: "source", attribute: "src", asset: true},
+	{element: "source", attribute: "srcset", asset: true},
+	{element: "link", attribute: "href", asset: true},
+	{element: "script", attribute: "src", asset: true},
+	{element: "iframe", attribute: "src", asset: true},
+	{element: "frame", attribute: "src", asset: true},
+	{element: "video", attribute: "src", asset: true},
+	{element: "video", attribute: "poster", asset: true},
+	{element: "audio", attribute: "src", asset: true},
+	{element: "track", attribute: "src", asset: true},
+	{element: "embed", attribute: "src", asset: true},
+	{element: "object", attribute: "data", asset: true},
+	{element: "input", attribute: "src", asset: true},
+}
+
+func (c *Context) linkExtractors() []LinkExtractor {
+	extractors := c.extractors
+	if extractors == nil {
+		extractors = DEFAULT_EXTRACTORS
+	}
+	if !c.skipAssets {
+		return extractors
+	}
+	pages := []LinkExtractor{}
+	for _, extractor := range extractors {
+		if !extractor.asset {
+			pages = append(pages, extractor)
+		}
+	}
+	return pages
+}
+
+// parseLinkExtractor parses an extra asset extractor such as "img:data-src".
+func parseLinkExtractor(raw string) (LinkExtractor, error) {
+	element, attribute, found := strings.Cut(raw, ":")
+	if !found || len(element) == 0 || len(attribute) == 0 {
+		return LinkExtractor{}, errors.New(INVALID_EXTRACTOR)
+	}
+	return LinkExtractor{element: strings.ToLower(element), attribute: strings.ToLower(attribute), asset: true}, nil
+}
+
+func isHtml(contentType string) bool {
+	if len(contentType) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+func skipLinkElement(n *html.Node) bool {
+	/*
+	 * <link rel=preconnect> and <link rel=dns-prefetch> point
+	 * at an origin rather than at something that can be fetched.
+	 */
+	if n.Data != "link" {
+		return false
+	}
+	for _, attr := range n.Attr {
+		if attr.Key == "rel" {
+			for _, rel := range strings.Fields(strings.ToLower(attr.Val)) {
+				if rel == "preconnect" || rel == "dns-prefetch" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func parseSrcset(srcset string) []string {
+	/*
+	 * Split a srcset attribute into its candidate URLs following
+	 * the HTML parsing rules: a URL runs until whitespace, trailing
+	 * commas belong to the separator, and the descriptors after it
+	 * run until the next comma.
+	 */
+	var urls []string
+	rest := srcset
+	for {
+		rest = strings.TrimLeft(rest, " \t\n\r\f,")
+		if len(rest) == 0 {
+			return urls
+		}
+		end := strings.IndexAny(rest, " \t\n\r\f")
+		if end == -1 {
+			end = len(rest)
+		}
+		candidate := rest[:end]
+		rest = rest[end:]
+		trimmed := strings.TrimRight(candidate, ",")
+		if len(trimmed) > 0 {
+			urls = append(urls, trimmed)
+		}
+		if len(trimmed) < len(candidate) {
+			continue
+		}
+		// Skip descriptors, which may contain commas inside parentheses.
+		depth := 0
+		i := 0
+		for ; i < len(rest); i++ {
+			if rest[i] == '(' {
+				depth++
+			} else if rest[i] == ')' && depth > 0 {
+				depth--
+			} else if rest[i] == ',' && depth == 0 {
+				break
+			}
+		}
+		rest = rest[i:]
+	}
+}
+
+func linkText(n *html.Node, attribute string) string {
+	if n.Data == "a" || n.Data == "area" {
+		text := nodeText(n)
+		if len(text) == 0 {
+			for _, attr := range n.Attr {
+				if attr.Key == "alt" || attr.Key == "title" || attr.Key == "aria-label" {
+					return attr.Val
+				}
+			}
+		}
+		return text
+	}
+	return "<" + n.Data + " " + attribute + ">"
+}
+
+func nodeText(n *html.Node) string {
+	var text strings.Builder
+	for c := range n.Descendants() {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+			text.WriteString(" ")
+		}
+	}
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+func getBaseUrl(n *html.Node, pageUrl *url.URL) *url.URL {
+	/*
+	 * The first <base href> of a document replaces the page
+	 * URL as the base every relative reference resolves against.
+	 */
+	for c := range n.Descendants() {
+		if c.Type == html.ElementNode && c.Data == "base" {
+			for _, attr := range c.Attr {
+				if attr.Key == "href" {
+					ref, err := url.Parse(strings.TrimSpace(attr.Val))
+					if err != nil {
+						return pageUrl
+					}
+					return pageUrl.ResolveReference(ref)
+				}
+			}
+		}
+	}
+	return pageUrl
+}
+
+func resolveLink(base *url.URL, pageUrl *url.URL, href string) (string, string, bool) {
+	/*
+	 * Resolve href against base as described in RFC 3986
+	 * and return the path (with query) to request from the
+	 * crawled host along with the fragment, if any. Links to
+	 * other hosts or schemes are not crawled.
+	 */
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", "", false
+	}
+	resolved := base.ResolveReference(ref)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return "", "", false
+	}
+	if resolved.Host != pageUrl.Host {
+		return "", "", false
+	}
+	return resolved.RequestURI(), resolved.Fragment, true
+}
+
+func getPageLinksTask(n *html.Node, pageUrl *url.URL, extractors []LinkExtractor) []Link {
+	var pageLinks []Link
+	base := getBaseUrl(n, pageUrl)
+
+	for c := range n.Descendants() {
+		var linkBuffer []Link
+		if c.Type != html.ElementNode || skipLinkElement(c) {
+			continue
+		}
+		for _, extractor := range extractors {
+			if extractor.element != c.Data {
+				continue
+			}
+			for _, attr := range c.Attr {
+				if attr.Key != extractor.attribute {
+					continue
+				}
+				refs := []string{attr.Val}
+				if strings.HasSuffix(attr.Key, "srcset") {
+					refs = parseSrcset(attr.Val)
+				}
+				for _, ref := range refs {
+					if path, fragment, ok := resolveLink(base, pageUrl, ref); ok {
+						linkBuffer = append(linkBuffer, Link{path: path, fragment: fragment, text: linkText(c, attr.Key), asset: extractor.asset})
+					}
+				}
+			}
+		}
+		pageLinks = append(pageLinks, linkBuffer...)
+	}
+	return pageLinks
+}
@@ -10,11 +10,39 @@ import (
 func getHelpString() string {
 	helpString := ""
 	helpString += "run                Run a Crestfile.\n"
+	helpString += "crawl --dir DIR    Check a build directory without a web server.\n"
+	helpString += "serve DIR          Serve a build directory on localhost and check it.\n"
+	helpString += "robots lint URL    Check robots.txt for mistakes.\n"
 	helpString += "help               Generate this message again.\n"
 	helpString += "-t/--test-http     Test http mode.\n"
+	helpString += "--test-html        Check that every crawled page is valid HTML.\n"
+	helpString += "--test-a11y        Check every crawled page for common accessibility problems.\n"
+	helpString += "--test-seo         Check the title, description, headings and canonical link of every page.\n"
 	helpString += "-v/--verbose       Print in verbose mode.\n"
 	helpString += "-q/--quiet         Print in quiet mode.\n"
-	helpString += "-f/--follow-robots Follow robots.txt."
+	helpString += "-f/--follow-robots Follow robots.txt.\n"
+	helpString += "--user-agent NAME  User-Agent header and robots.txt token (default Crestbot).\n"
+	helpString += "--robots-unreachable allow|disallow\n"
+	helpString += "                   What to crawl when robots.txt returns 5xx (default disallow).\n"
+	helpString += "--concurrency N    Fetch up to N pages at the same time.\n"
+	helpString += "--rate N           Send at most N requests per second to a host.\n"
+	helpString += "--burst N          Allow bursts of up to N requests above the rate.\n"
+	helpString += "--allow-status CODES\n"
+	helpString += "                   Let these statuses pass besides 200, for example 204,410.\n"
+	helpString += "--redirect CODE=POLICY\n"
+	helpString += "                   Let 301/302/303/307/308 redirects pass, warn or fail.\n"
+	helpString += "--max-redirects N  Fail redirect chains longer than N hops (default 10).\n"
+	helpString += "--title-length MIN-MAX\n"
+	helpString += "                   Accepted length of page titles (default 10-60).\n"
+	helpString += "--description-length MIN-MAX\n"
+	helpString += "                   Accepted length of meta descriptions (default 50-160).\n"
+	helpString += "--fail-fast        Stop at the first broken link.\n"
+	helpString += "--sitemap          Seed the crawl from sitemap.xml and report pages missing from it.\n"
+	helpString += "--orphans          Report HTML files in the build directory nothing links to.\n"
+	helpString += "--check-fragments  Check that page#fragment links point at an existing id.\n"
+	helpString += "--no-assets        Only check anchors, not images, scripts, stylesheets...\n"
+	helpString += "--report json=FILE Write a machine readable report of the crawl.\n"
+	helpString += "--report junit=FILE Write a JUnit XML report of the crawl."
 	return helpString
 }
 
@@ -29,7 +57,8 @@ func main() {
 		verbose:      false,
 		quiet:        false,
 		followRobots: false,
-		depth:        20,
+		depth:        DEFAULT_DEPTH,
+		concurrency:  DEFAULT_CONCURRENCY,
 
 		exclude: []string{},
 	}
@@ -40,6 +69,7 @@ func main() {
 
 		variable:       make(map[string]string),
 		instructionSet: []string{},
+		statements:     [][]string{},
 
 		offset: 0,
 		row:    0,
@@ -53,6 +83,18 @@ func main() {
 			}
 		} else if args[1] == "run" {
 			fmt.Fprintln(os.Stderr, "It seems like you inputted an invalid path for your Crestfile.")
+		} else if args[1] == "crawl" {
+			if err := HandleCrawl(args, &ctx); err != nil {
+				log.Fatal(err)
+			}
+		} else if args[1] == "serve" {
+			if err := HandleServe(args, &ctx); err != nil {
+				log.Fatal(err)
+			}
+		} else if args[1] == "robots" {
+			if err := HandleRobots(args, &ctx); err != nil {
+				log.Fatal(err)
+			}
 		} else if args[1] == "help" {
 			fmt.Fprintln(os.Stderr, getHelpString())
 		} else {
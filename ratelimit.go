@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * Token bucket limiting requests to rate per second with
+ * bursts of up to burst requests. A rate of zero or less
+ * never waits.
+ */
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+func (l *RateLimiter) Wait() {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// Take a token even if there is none yet, and wait until it is earned.
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Rate limiters of every host crawled, created on first use.
+type HostLimiter struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    int
+	limiters map[string]*RateLimiter
+}
+
+func NewHostLimiter(rate float64, burst int) *HostLimiter {
+	return &HostLimiter{rate: rate, burst: burst, limiters: make(map[string]*RateLimiter)}
+}
+
+func (h *HostLimiter) get(host string) *RateLimiter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	limiter, ok := h.limiters[host]
+	if !ok {
+		limiter = NewRateLimiter(h.rate, h.burst)
+		h.limiters[host] = limiter
+	}
+	return limiter
+}
+
+func (h *HostLimiter) Wait(host string) {
+	h.get(host).Wait()
+}
+
+func (h *HostLimiter) AdoptCrawlDelay(host string, delay float64) {
+	/*
+	 * A Crawl-delay only ever slows the crawl down: it
+	 * replaces the configured rate when that is faster,
+	 * and requests are no longer sent in bursts.
+	 */
+	limiter := h.get(host)
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	rate := 1 / delay
+	if limiter.rate <= 0 || rate < limiter.rate {
+		limiter.rate = rate
+	}
+	limiter.burst = 1
+	limiter.tokens = min(limiter.tokens, 1)
+}
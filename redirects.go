@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_MAX_REDIRECTS = 10
+
+// Status codes a redirect policy can be set for.
+var REDIRECT_STATUSES = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// What a redirect counts as: pass (the default), warn or fail.
+var REDIRECT_POLICIES = []string{"pass", "warn", "fail"}
+
+// A single hop of a redirect chain: url answered with status.
+type Redirect struct {
+	url    string
+	status int
+}
+
+/*
+ * RedirectError is returned for redirect chains crest
+ * refuses to follow: loops, chains longer than the
+ * maximum, and redirects leaving localhost or https.
+ */
+type RedirectError struct {
+	problem string
+	chain   []Redirect
+	target  string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("%s %s", e.problem, formatRedirectChain(e.chain, e.target))
+}
+
+// formatRedirectChain writes a chain as "/a -301-> /b -302-> /c".
+func formatRedirectChain(chain []Redirect, target string) string {
+	var b strings.Builder
+	for _, hop := range chain {
+		fmt.Fprintf(&b, "%s -%d-> ", hop.url, hop.status)
+	}
+	b.WriteString(target)
+	return b.String()
+}
+
+func isLocalhost(hostname string) bool {
+	return hostname == "localhost" || hostname == "127.0.0.1" || hostname == "::1"
+}
+
+// parseRedirectPolicy checks a policy such as 301 warn.
+func parseRedirectPolicy(code string, policy string) (int, string, error) {
+	status, err := strconv.Atoi(code)
+	if err != nil || !slices.Contains(REDIRECT_STATUSES, status) || !slices.Contains(REDIRECT_POLICIES, policy) {
+		return 0, "", errors.New(INVALID_REDIRECT_POLICY)
+	}
+	return status, policy, nil
+}
+
+func (c *Context) setRedirectPolicy(code string, policy string) error {
+	status, policy, err := parseRedirectPolicy(code, policy)
+	if err != nil {
+		return err
+	}
+	if c.redirectPolicies == nil {
+		c.redirectPolicies = make(map[int]string)
+	}
+	c.redirectPolicies[status] = policy
+	return nil
+}
+
+func (c *Context) redirectPolicy(status int) string {
+	if policy, ok := c.redirectPolicies[status]; ok {
+		return policy
+	}
+	return "pass"
+}
+
+func fetchWithRedirects(host string, path string, ctx *Context) (*http.Response, []Redirect, error) {
+	/*
+	 * Follow redirects one hop at a time instead of letting
+	 * http.Client do it, so the whole chain is known and
+	 * can be checked. Every hop counts against the rate
+	 * limit like any other request. Whether the final
+	 * status passes depends on the path first requested.
+	 */
+	client := *ctx.httpClient()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	maxRedirects := ctx.maxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = DEFAULT_MAX_REDIRECTS
+	}
+
+	expected := ctx.expectedStatuses(path)
+	target := host + path
+	var chain []Redirect
+	seen := make(map[string]bool)
+	for {
+		if ctx.limiter != nil {
+			ctx.limiter.Wait(host)
+		}
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			return nil, chain, err
+		}
+		req.Header.Set("User-Agent", ctx.agent())
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, chain, err
+		}
+		// A redirect that is expected is a final answer.
+		if !slices.Contains(REDIRECT_STATUSES, res.StatusCode) || slices.Contains(expected, res.StatusCode) {
+			if !ctx.acceptsStatus(path, res.StatusCode) {
+				res.Body.Close()
+				return nil, chain, &StatusError{url: target, status: res.StatusCode, expected: expected}
+			}
+			return res, chain, nil
+		}
+
+		location, err := res.Location()
+		res.Body.Close()
+		if err != nil {
+			return nil, chain, &StatusError{url: target, status: res.StatusCode, expected: expected}
+		}
+		seen[target] = true
+		chain = append(chain, Redirect{url: target, status: res.StatusCode})
+		next := location.String()
+
+		var problem string
+		if seen[next] {
+			problem = REDIRECT_LOOP
+		} else if len(chain) > maxRedirects {
+			problem = fmt.Sprintf("%s %d:", REDIRECT_TOO_LONG, maxRedirects)
+		} else if !isLocalhost(location.Hostname()) {
+			problem = REDIRECT_OFFSITE
+		} else if req.URL.Scheme == "https" && location.Scheme == "http" {
+			problem = REDIRECT_DOWNGRADE
+		}
+		if len(problem) > 0 {
+			return nil, chain, &RedirectError{problem: problem, chain: chain, target: next}
+		}
+		target = next
+	}
+}
+
+func (c *Context) checkRedirectPolicy(result PageResult) error {
+	/*
+	 * Apply the redirect policies to every hop of the chain
+	 * that led to a page. With failFast the first redirect
+	 * counting as a failure ends the crawl.
+	 */
+	for _, hop := range result.redirects {
+		policy := c.redirectPolicy(hop.status)
+		if policy == "pass" {
+			continue
+		}
+		err := errors.New(fmt.Sprintf("%d redirect %s", hop.status, formatRedirectChain(result.redirects, result.url)))
+		failure := LinkFailure{link: result.link, check: "redirect", status: hop.status, err: err}
+		if policy == "warn" {
+			c.warnv(fmt.Sprintf("Redirect %s", result.link.path), err.Error())
+			c.warnings = append(c.warnings, failure)
+			continue
+		}
+		c.printv(os.Stderr, fmt.Sprintf("Redirect %s", result.link.path), err.Error())
+		c.failures = append(c.failures, failure)
+		if c.failFast {
+			return err
+		}
+	}
+	return nil
+}
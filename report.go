@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+ * Bump REPORT_SCHEMA_VERSION whenever a field of the
+ * report is renamed, removed or changes meaning. Adding
+ * fields does not require a new version.
+ */
+const REPORT_SCHEMA_VERSION = 1
+
+type ReportPage struct {
+	Url            string           `json:"url"`
+	Path           string           `json:"path"`
+	Status         int              `json:"status"`
+	ResponseTimeMs float64          `json:"responseTimeMs"`
+	ContentType    string           `json:"contentType"`
+	Depth          int              `json:"depth"`
+	Asset          bool             `json:"asset"`
+	Referrers      []string         `json:"referrers"`
+	Redirects      []ReportRedirect `json:"redirects,omitempty"`
+	Error          string           `json:"error,omitempty"`
+}
+
+type ReportRedirect struct {
+	Url    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+type ReportFailure struct {
+	Check      string `json:"check"`
+	Url        string `json:"url"`
+	Line       int    `json:"line,omitempty"`
+	Column     int    `json:"column,omitempty"`
+	Rule       string `json:"rule,omitempty"`
+	Status     int    `json:"status"`
+	Referrer   string `json:"referrer"`
+	AnchorText string `json:"anchorText"`
+	Message    string `json:"message"`
+}
+
+type Report struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	GeneratedAt   string          `json:"generatedAt"`
+	Host          string          `json:"host"`
+	Pages         []ReportPage    `json:"pages"`
+	Failures      []ReportFailure `json:"failures"`
+	Warnings      []ReportFailure `json:"warnings"`
+}
+
+// parseReport splits a report option such as "json=report.json".
+func parseReport(raw string) (string, string, error) {
+	format, path, found := strings.Cut(raw, "=")
+	if !found || len(path) == 0 {
+		return "", "", errors.New(INVALID_REPORT)
+	}
+	if format != "json" && format != "junit" {
+		return "", "", errors.New(INVALID_REPORT)
+	}
+	return format, path, nil
+}
+
+func failureUrl(failure LinkFailure) string {
+	if failure.check == "fragment" {
+		return failure.link.path + "#" + failure.link.fragment
+	}
+	return failure.link.path
+}
+
+func (c *Context) buildReport() Report {
+	report := Report{
+		SchemaVersion: REPORT_SCHEMA_VERSION,
+		GeneratedAt:   time.Now().UTC().Format(time.RFC3339),
+		Host:          c.host,
+		Pages:         []ReportPage{},
+		Failures:      []ReportFailure{},
+		Warnings:      []ReportFailure{},
+	}
+	for _, page := range c.pages {
+		reportPage := ReportPage{
+			Url:            c.host + page.link.path,
+			Path:           page.link.path,
+			Status:         page.status,
+			ResponseTimeMs: float64(page.elapsed.Microseconds()) / 1000,
+			ContentType:    page.contentType,
+			Depth:          page.depth,
+			Asset:          page.link.asset,
+			Referrers:      c.referrers[page.link.path],
+		}
+		if reportPage.Referrers == nil {
+			reportPage.Referrers = []string{}
+		}
+		for _, hop := range page.redirects {
+			reportPage.Redirects = append(reportPage.Redirects, ReportRedirect{Url: hop.url, Status: hop.status})
+		}
+		if page.err != nil {
+			reportPage.Error = page.err.Error()
+		}
+		report.Pages = append(report.Pages, reportPage)
+	}
+	for _, failure := range c.failures {
+		report.Failures = append(report.Failures, c.reportFailure(failure))
+	}
+	for _, warning := range c.warnings {
+		report.Warnings = append(report.Warnings, c.reportFailure(warning))
+	}
+	return report
+}
+
+func (c *Context) reportFailure(failure LinkFailure) ReportFailure {
+	return ReportFailure{
+		Check:      failure.check,
+		Url:        c.host + failureUrl(failure),
+		Line:       failure.line,
+		Column:     failure.col,
+		Rule:       failure.rule,
+		Status:     failure.status,
+		Referrer:   failure.link.referrer,
+		AnchorText: failure.link.text,
+		Message:    failure.err.Error(),
+	}
+}
+
+func writeJsonReport(report Report, path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (c *Context) writeReports() error {
+	if len(c.reports) == 0 {
+		return nil
+	}
+	report := c.buildReport()
+	for _, raw := range c.reports {
+		format, path, err := parseReport(raw)
+		if err != nil {
+			return err
+		}
+		if format == "json" {
+			err = writeJsonReport(report, path)
+		}
+		if format == "junit" {
+			err = writeJunitReport(c.buildJunitReport(), path)
+		}
+		if err != nil {
+			return err
+		}
+		c.printv(os.Stdout, "Wrote "+format+" report", "Wrote "+format+" report to "+path)
+	}
+	return nil
+}
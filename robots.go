@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// User-Agent crest identifies itself with, in requests and in robots.txt groups.
+const DEFAULT_USER_AGENT = "Crestbot"
+
+func (c *Context) agent() string {
+	if len(c.userAgent) == 0 {
+		return DEFAULT_USER_AGENT
+	}
+	return c.userAgent
+}
+
+/*
+ * A group of robots.txt rules. Every User-agent line
+ * directly preceding the rules shares them.
+ */
+type RobotPolicy struct {
+	agents     []string
+	allow      []string
+	disallow   []string
+	crawlDelay float64
+}
+
+func parseRobotsLine(line string) (string, string, bool) {
+	if comment := strings.Index(line, "#"); comment != -1 {
+		line = line[:comment]
+	}
+	key, value, found := strings.Cut(line, ":")
+	if !found {
+		return "", "", false
+	}
+	return strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value), true
+}
+
+func parseRobots(content string) []RobotPolicy {
+	/*
+	 * Parse robots.txt as described in RFC 9309. Keys are
+	 * case insensitive, comments run from # to the end of
+	 * the line, and a User-agent line that follows rules
+	 * starts a new group. Lines the RFC does not describe
+	 * are ignored, as are rules outside of any group.
+	 */
+	var robotPolicies []RobotPolicy
+	current := -1
+	inRules := false
+
+	for _, line := range strings.Split(content, "\n") {
+		key, value, ok := parseRobotsLine(line)
+		if !ok {
+			continue
+		}
+
+		if key == "user-agent" {
+			if current == -1 || inRules {
+				robotPolicies = append(robotPolicies, RobotPolicy{})
+				current = len(robotPolicies) - 1
+				inRules = false
+			}
+			robotPolicies[current].agents = append(robotPolicies[current].agents, value)
+		} else if key == "allow" || key == "disallow" {
+			if current == -1 {
+				continue
+			}
+			inRules = true
+			// An empty rule matches nothing.
+			if len(value) == 0 {
+				continue
+			}
+			if key == "allow" {
+				robotPolicies[current].allow = append(robotPolicies[current].allow, value)
+			} else {
+				robotPolicies[current].disallow = append(robotPolicies[current].disallow, value)
+			}
+		} else if key == "crawl-delay" {
+			// Crawl-delay is not part of the RFC but widely used, in seconds.
+			delay, err := strconv.ParseFloat(value, 64)
+			if current == -1 || err != nil || delay < 0 {
+				continue
+			}
+			inRules = true
+			robotPolicies[current].crawlDelay = delay
+		}
+	}
+	return robotPolicies
+}
+
+func RobotParser(url string, ctx *Context) ([]RobotPolicy, error) {
+	content, err := fetchRobots(url, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseRobots(content), nil
+}
+
+func fetchRobots(url string, ctx *Context) (string, error) {
+	robot_path := url + "/robots.txt"
+
+	client := ctx.httpClient()
+	req, err := http.NewRequest(http.MethodGet, robot_path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", ctx.agent())
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", &StatusError{url: robot_path, status: res.StatusCode}
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// productToken reduces a User-Agent such as "Googlebot/2.1 (+http://...)" to "googlebot".
+func productToken(agent string) string {
+	agent = strings.TrimSpace(agent)
+	if end := strings.IndexAny(agent, "/ \t"); end != -1 {
+		agent = agent[:end]
+	}
+	return strings.ToLower(agent)
+}
+
+func (p *RobotPolicy) merge(policy RobotPolicy) {
+	p.agents = append(p.agents, policy.agents...)
+	p.allow = append(p.allow, policy.allow...)
+	p.disallow = append(p.disallow, policy.disallow...)
+	p.crawlDelay = max(p.crawlDelay, policy.crawlDelay)
+}
+
+func robotRulesFor(policies []RobotPolicy, agent string) RobotPolicy {
+	/*
+	 * Every group naming the product token is merged into
+	 * one. When no group names it, the * groups apply, and
+	 * when there are none of those everything is allowed.
+	 */
+	token := productToken(agent)
+	var matched, wildcard RobotPolicy
+	found := false
+
+	for _, policy := range policies {
+		for _, policyAgent := range policy.agents {
+			policyAgent = strings.ToLower(strings.TrimSpace(policyAgent))
+			if policyAgent == token {
+				matched.merge(policy)
+				found = true
+				break
+			}
+			if policyAgent == "*" {
+				wildcard.merge(policy)
+				break
+			}
+		}
+	}
+	if found {
+		return matched
+	}
+	return wildcard
+}
+
+// encodeRobotsPath percent-encodes the octets that are not ASCII so patterns and paths compare equal.
+func encodeRobotsPath(path string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] >= 0x80 || path[i] <= 0x20 {
+			fmt.Fprintf(&encoded, "%%%02X", path[i])
+		} else {
+			encoded.WriteByte(path[i])
+		}
+	}
+	return encoded.String()
+}
+
+func robotsPatternMatch(pattern string, path string) bool {
+	/*
+	 * Patterns match path prefixes. * matches any sequence
+	 * of characters and a trailing $ anchors the pattern to
+	 * the end of the path.
+	 */
+	pattern = encodeRobotsPath(pattern)
+	path = encodeRobotsPath(path)
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || len(rest) == 0
+	}
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		index := strings.Index(rest, part)
+		if index == -1 {
+			return false
+		}
+		rest = rest[index+len(part):]
+	}
+	return true
+}
+
+func robotsAllowed(rules RobotPolicy, path string) (bool, string) {
+	/*
+	 * The longest matching rule wins. When an Allow and a
+	 * Disallow rule are equally long, Allow wins. Returns
+	 * the deciding rule, if any, so it can be reported.
+	 */
+	if path == "/robots.txt" {
+		return true, ""
+	}
+	allowed := true
+	rule := ""
+	longest := -1
+	for _, pattern := range rules.disallow {
+		if robotsPatternMatch(pattern, path) && len(encodeRobotsPath(pattern)) > longest {
+			allowed = false
+			rule = "Disallow: " + pattern
+			longest = len(encodeRobotsPath(pattern))
+		}
+	}
+	for _, pattern := range rules.allow {
+		if robotsPatternMatch(pattern, path) && len(encodeRobotsPath(pattern)) >= longest {
+			allowed = true
+			rule = "Allow: " + pattern
+			longest = len(encodeRobotsPath(pattern))
+		}
+	}
+	return allowed, rule
+}
+
+/*
+ * The robots.txt rules of a host, fetched once per crawl.
+ * When robots.txt could not be used, blocked is set to the
+ * reason every path is disallowed, if they are.
+ */
+type RobotsEntry struct {
+	rules   RobotPolicy
+	blocked string
+}
+
+type RobotsCache struct {
+	mu      sync.Mutex
+	entries map[string]*RobotsEntry
+}
+
+func NewRobotsCache() *RobotsCache {
+	return &RobotsCache{entries: make(map[string]*RobotsEntry)}
+}
+
+func (r *RobotsCache) Get(host string, ctx *Context) *RobotsEntry {
+	/*
+	 * Unavailable robots.txt (4xx) allows everything.
+	 * Unreachable robots.txt (5xx or a network error)
+	 * disallows everything, unless ctx.robotsUnreachable
+	 * says to allow it.
+	 */
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.entries[host]; ok {
+		return entry
+	}
+
+	entry := &RobotsEntry{}
+	policies, err := RobotParser(host, ctx)
+	var statusErr *StatusError
+	if err == nil {
+		entry.rules = robotRulesFor(policies, ctx.agent())
+		ctx.printv(os.Stdout, "Generated robot policies", fmt.Sprintf("Generated robot policies for %s from %s/robots.txt", productToken(ctx.agent()), host))
+		if entry.rules.crawlDelay > 0 && ctx.limiter != nil {
+			ctx.limiter.AdoptCrawlDelay(host, entry.rules.crawlDelay)
+			ctx.printv(os.Stdout, "Adopted Crawl-delay", fmt.Sprintf("Adopted Crawl-delay of %gs for %s", entry.rules.crawlDelay, host))
+		}
+	} else if errors.As(err, &statusErr) && statusErr.status >= 400 && statusErr.status < 500 {
+		ctx.printv(os.Stdout, "No robots.txt, allowing everything", fmt.Sprintf("%s/robots.txt returned %d, allowing everything", host, statusErr.status))
+	} else if ctx.robotsUnreachable == "allow" {
+		ctx.printv(os.Stderr, "robots.txt unreachable, allowing everything", fmt.Sprintf("%s/robots.txt is unreachable (%s), allowing everything", host, err))
+	} else {
+		entry.blocked = fmt.Sprintf("robots.txt is unreachable (%s)", err)
+		ctx.printv(os.Stderr, "robots.txt unreachable, disallowing everything", fmt.Sprintf("%s/robots.txt is unreachable (%s), disallowing everything", host, err))
+	}
+	r.entries[host] = entry
+	return entry
+}
+
+// Allowed reports whether path may be crawled and the reason when it may not.
+func (e *RobotsEntry) Allowed(path string) (bool, string) {
+	if len(e.blocked) > 0 && path != "/robots.txt" {
+		return false, e.blocked
+	}
+	allowed, rule := robotsAllowed(e.rules, path)
+	return allowed, "robots.txt rule \"" + rule + "\""
+}
+
+func GetAllowedRobots(url string, links []string, ctx *Context) []string {
+	/*
+	 * Compare the policy provided by robots.txt file
+	 * in order to determine which paths to permit.
+	 * The final list of allowed paths is called the
+	 * delta.
+	 */
+	if ctx.robots == nil {
+		ctx.robots = NewRobotsCache()
+	}
+	entry := ctx.robots.Get(url, ctx)
+	var delta []string
+
+	for _, link := range links {
+		allowed, reason := entry.Allowed(link)
+		if allowed {
+			delta = append(delta, link)
+		} else {
+			ctx.printv(os.Stdout, "Skipped link blocked by robots.txt", fmt.Sprintf("Skipped %s, blocked by %s", link, reason))
+		}
+	}
+
+	return delta
+}
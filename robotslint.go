@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Directives crest understands, in the spelling robots.txt files usually use.
+var ROBOTS_DIRECTIVES = map[string]string{
+	"user-agent":  "User-agent",
+	"allow":       "Allow",
+	"disallow":    "Disallow",
+	"crawl-delay": "Crawl-delay",
+	"sitemap":     "Sitemap",
+}
+
+type robotsLintRule struct {
+	text    string
+	pattern string
+	allow   bool
+	line    int
+}
+
+type robotsLintGroup struct {
+	agents      []string
+	line        int
+	rules       map[string]int
+	ordered     []robotsLintRule
+	unreachable map[int]bool
+}
+
+// names reports whether the group lists the product token.
+func (g *robotsLintGroup) names(token string) bool {
+	for _, agent := range g.agents {
+		if strings.ToLower(agent) == token {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Context) robotsProblem(line int, message string) {
+	c.printv(os.Stderr, "robots.txt problem", fmt.Sprintf("robots.txt:%d: %s", line, message))
+	c.failures = append(c.failures, LinkFailure{link: Link{path: "/robots.txt"}, check: "robots", line: line, err: errors.New(message)})
+}
+
+func lintRobotsPattern(pattern string) string {
+	if len(pattern) > 0 && pattern[0] != '/' && pattern[0] != '*' {
+		return "never matches anything because paths always start with /"
+	}
+	if index := strings.Index(pattern, "$"); index != -1 && index != len(pattern)-1 {
+		return "$ only anchors the pattern at its very end"
+	}
+	return ""
+}
+
+// robotsCovers reports whether general matches every path specific matches.
+func robotsCovers(general string, specific string) bool {
+	/*
+	 * Only the literal start of specific is compared, so
+	 * some covers are missed but none is made up. Paths
+	 * specific matches all start with that literal, and an
+	 * unanchored pattern matching a path also matches every
+	 * path starting with it.
+	 */
+	literal, _, wildcard := strings.Cut(specific, "*")
+	if !wildcard && strings.HasSuffix(literal, "$") {
+		return robotsPatternMatch(general, strings.TrimSuffix(literal, "$"))
+	}
+	return !strings.HasSuffix(general, "$") && robotsPatternMatch(general, literal)
+}
+
+func (c *Context) lintRobotsSyntax(content string) ([]string, map[string]int) {
+	/*
+	 * Report the lines RobotParser ignores or reads differently
+	 * than their author probably intended, and rules that can
+	 * never decide anything. Returns the sitemaps and the line
+	 * of every rule so later problems can point at them.
+	 */
+	var sitemaps []string
+	ruleLines := make(map[string]int)
+	var group *robotsLintGroup
+	var groups []*robotsLintGroup
+	inRules := false
+
+	for i, raw := range strings.Split(content, "\n") {
+		line := i + 1
+		if comment := strings.Index(raw, "#"); comment != -1 {
+			raw = raw[:comment]
+		}
+		if len(strings.TrimSpace(raw)) == 0 {
+			continue
+		}
+		key, value, ok := parseRobotsLine(raw)
+		if !ok {
+			c.robotsProblem(line, fmt.Sprintf("%q is not a directive, it is missing a colon", strings.TrimSpace(raw)))
+			continue
+		}
+		canonical, known := ROBOTS_DIRECTIVES[key]
+		if !known {
+			c.robotsProblem(line, fmt.Sprintf("unknown directive %q is ignored", strings.TrimSpace(strings.SplitN(raw, ":", 2)[0])))
+			continue
+		}
+		if written := strings.TrimSpace(strings.SplitN(raw, ":", 2)[0]); written != canonical {
+			c.robotsProblem(line, fmt.Sprintf("%q is usually written %q, some crawlers only understand that spelling", written, canonical))
+		}
+
+		if key == "sitemap" {
+			sitemaps = append(sitemaps, value)
+			ruleLines["Sitemap: "+value] = line
+			continue
+		}
+		if key == "user-agent" {
+			if len(value) == 0 {
+				c.robotsProblem(line, "User-agent without a product token matches no crawler")
+			}
+			if group == nil || inRules {
+				group = &robotsLintGroup{line: line, rules: make(map[string]int), unreachable: make(map[int]bool)}
+				groups = append(groups, group)
+				inRules = false
+			}
+			group.agents = append(group.agents, value)
+			continue
+		}
+		if group == nil {
+			c.robotsProblem(line, fmt.Sprintf("%s before any User-agent line applies to no crawler", canonical))
+			continue
+		}
+		inRules = true
+
+		if key == "crawl-delay" {
+			if delay, err := strconv.ParseFloat(value, 64); err != nil || delay < 0 {
+				c.robotsProblem(line, fmt.Sprintf("Crawl-delay %q is not a number of seconds", value))
+			}
+			continue
+		}
+		if len(value) == 0 {
+			continue
+		}
+		if problem := lintRobotsPattern(value); len(problem) > 0 {
+			c.robotsProblem(line, fmt.Sprintf("%s: %s %s", canonical, value, problem))
+		}
+		rule := canonical + ": " + value
+		if previous, ok := group.rules[rule]; ok {
+			c.robotsProblem(line, fmt.Sprintf("%q repeats line %d of the same group", rule, previous))
+			continue
+		}
+		group.rules[rule] = line
+		if _, ok := ruleLines[rule]; !ok {
+			ruleLines[rule] = line
+		}
+
+		/*
+		 * The longest matching rule wins and Allow wins ties,
+		 * so a rule never applies when a rule of the other
+		 * kind matches every path it matches and always wins.
+		 */
+		current := robotsLintRule{text: rule, pattern: value, allow: key == "allow", line: line}
+		unreachable := func(shadowed robotsLintRule, winner robotsLintRule) {
+			if group.unreachable[shadowed.line] {
+				return
+			}
+			group.unreachable[shadowed.line] = true
+			c.robotsProblem(shadowed.line, fmt.Sprintf("%q is unreachable, %q on line %d always wins", shadowed.text, winner.text, winner.line))
+		}
+		for _, previous := range group.ordered {
+			if previous.allow == current.allow {
+				continue
+			}
+			allow, disallow := current, previous
+			if !current.allow {
+				allow, disallow = previous, current
+			}
+			allowLength, disallowLength := len(encodeRobotsPath(allow.pattern)), len(encodeRobotsPath(disallow.pattern))
+			if allowLength >= disallowLength && robotsCovers(allow.pattern, disallow.pattern) {
+				unreachable(disallow, allow)
+			} else if disallowLength > allowLength && robotsCovers(disallow.pattern, allow.pattern) {
+				unreachable(allow, disallow)
+			}
+		}
+		group.ordered = append(group.ordered, current)
+	}
+
+	/*
+	 * A crawler only follows the * groups when no group names
+	 * its product token, so those rules never apply to it.
+	 */
+	token := productToken(c.agent())
+	for _, own := range groups {
+		if !own.names(token) {
+			continue
+		}
+		for _, group := range groups {
+			if group.names("*") && !group.names(token) {
+				c.robotsProblem(group.line, fmt.Sprintf("the * group does not apply to %s, which follows its own group on line %d", token, own.line))
+			}
+		}
+		break
+	}
+	return sitemaps, ruleLines
+}
+
+func LintRobots(host string, path string, ctx *Context) error {
+	/*
+	 * The testRobots test. Besides the syntax of robots.txt
+	 * this checks that it does not block pages linked from
+	 * the start page and that its sitemaps exist. Sitemaps
+	 * are looked up on the host being tested, whatever host
+	 * their URL names, so production URLs can be checked
+	 * against a local build.
+	 */
+	content, err := fetchRobots(host, ctx)
+	if err != nil {
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) {
+			return err
+		}
+		ctx.failures = append(ctx.failures, LinkFailure{link: Link{path: "/robots.txt"}, check: "robots", status: statusErr.status, err: err})
+		ctx.printv(os.Stderr, "robots.txt is missing", err.Error())
+		return nil
+	}
+	ctx.printv(os.Stdout, "Linting robots.txt", fmt.Sprintf("Linting %s/robots.txt", host))
+	sitemaps, ruleLines := ctx.lintRobotsSyntax(content)
+
+	if len(path) == 0 {
+		path = "/"
+	}
+	rules := robotRulesFor(parseRobots(content), ctx.agent())
+	res, err := Page(host, path, ctx)
+	if err != nil {
+		return err
+	}
+	node, err := html.Parse(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	blocked := make(map[string]bool)
+	for _, link := range getPageLinksTask(node, res.Request.URL, DEFAULT_EXTRACTORS) {
+		allowed, rule := robotsAllowed(rules, link.path)
+		if allowed || link.asset || blocked[link.path] {
+			continue
+		}
+		blocked[link.path] = true
+		ctx.robotsProblem(ruleLines[rule], fmt.Sprintf("%q blocks %s, which is linked from %s", rule, link.path, path))
+	}
+
+	for _, sitemap := range sitemaps {
+		sitemapUrl, ok := sitemapPath(sitemap)
+		if !ok {
+			ctx.robotsProblem(ruleLines["Sitemap: "+sitemap], fmt.Sprintf("sitemap %q must be an absolute URL", sitemap))
+			continue
+		}
+		res, err := Page(host, sitemapUrl, ctx)
+		if err != nil {
+			ctx.robotsProblem(ruleLines["Sitemap: "+sitemap], fmt.Sprintf("sitemap %s does not exist: %s", sitemap, err))
+			continue
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+/*
+ * A small CSS selector engine, enough for asserting on the
+ * structure of static pages. It understands type, universal,
+ * #id, .class and attribute selectors ([a], [a=v], [a~=v],
+ * [a^=v], [a$=v], [a*=v]), the descendant and child (>)
+ * combinators, and selector lists separated by commas.
+ */
+type Selector [][]SelectorPart
+
+type SelectorPart struct {
+	tag        string
+	id         string
+	classes    []string
+	attrs      []AttrSelector
+	combinator byte // How this part relates to the one before it: ' ' or '>'.
+}
+
+type AttrSelector struct {
+	name  string
+	op    string
+	value string
+}
+
+func isIdentChar(c byte) bool {
+	return c == '-' || c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+type selectorParser struct {
+	raw    string
+	offset int
+}
+
+func (p *selectorParser) error() error {
+	return errors.New(fmt.Sprintf("%s %q at offset %d", INVALID_SELECTOR, p.raw, p.offset))
+}
+
+func (p *selectorParser) peek() byte {
+	if p.offset < len(p.raw) {
+		return p.raw[p.offset]
+	}
+	return 0
+}
+
+func (p *selectorParser) skipSpace() bool {
+	skipped := false
+	for p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\n' {
+		p.offset++
+		skipped = true
+	}
+	return skipped
+}
+
+func (p *selectorParser) ident() (string, error) {
+	start := p.offset
+	for p.offset < len(p.raw) && isIdentChar(p.raw[p.offset]) {
+		p.offset++
+	}
+	if start == p.offset {
+		return "", p.error()
+	}
+	return p.raw[start:p.offset], nil
+}
+
+func (p *selectorParser) attr() (AttrSelector, error) {
+	var attr AttrSelector
+	p.offset++ // [
+	p.skipSpace()
+	name, err := p.ident()
+	if err != nil {
+		return attr, err
+	}
+	attr.name = strings.ToLower(name)
+	p.skipSpace()
+	if p.peek() == ']' {
+		p.offset++
+		return attr, nil
+	}
+	for _, op := range []string{"=", "~=", "^=", "$=", "*="} {
+		if strings.HasPrefix(p.raw[p.offset:], op) {
+			attr.op = op
+			p.offset += len(op)
+			break
+		}
+	}
+	if len(attr.op) == 0 {
+		return attr, p.error()
+	}
+	p.skipSpace()
+	if quote := p.peek(); quote == '"' || quote == '\'' {
+		end := strings.IndexByte(p.raw[p.offset+1:], quote)
+		if end == -1 {
+			return attr, p.error()
+		}
+		attr.value = p.raw[p.offset+1 : p.offset+1+end]
+		p.offset += end + 2
+	} else if attr.value, err = p.ident(); err != nil {
+		return attr, err
+	}
+	p.skipSpace()
+	if p.peek() != ']' {
+		return attr, p.error()
+	}
+	p.offset++
+	return attr, nil
+}
+
+func (p *selectorParser) compound() (SelectorPart, error) {
+	var part SelectorPart
+	if p.peek() == '*' {
+		p.offset++
+	} else if isIdentChar(p.peek()) {
+		tag, _ := p.ident()
+		part.tag = strings.ToLower(tag)
+	}
+	for {
+		switch p.peek() {
+		case '#':
+			p.offset++
+			id, err := p.ident()
+			if err != nil {
+				return part, err
+			}
+			part.id = id
+		case '.':
+			p.offset++
+			class, err := p.ident()
+			if err != nil {
+				return part, err
+			}
+			part.classes = append(part.classes, class)
+		case '[':
+			attr, err := p.attr()
+			if err != nil {
+				return part, err
+			}
+			part.attrs = append(part.attrs, attr)
+		default:
+			return part, nil
+		}
+	}
+}
+
+func parseSelector(raw string) (Selector, error) {
+	p := selectorParser{raw: raw}
+	var selector Selector
+	var parts []SelectorPart
+	combinator := byte(' ')
+	p.skipSpace()
+	for {
+		start := p.offset
+		part, err := p.compound()
+		if err != nil {
+			return nil, err
+		}
+		if p.offset == start {
+			return nil, p.error()
+		}
+		part.combinator = combinator
+		parts = append(parts, part)
+
+		spaced := p.skipSpace()
+		switch c := p.peek(); {
+		case c == 0:
+			return append(selector, parts), nil
+		case c == ',':
+			p.offset++
+			p.skipSpace()
+			selector = append(selector, parts)
+			parts = nil
+			combinator = ' '
+		case c == '>':
+			p.offset++
+			p.skipSpace()
+			combinator = '>'
+		case spaced:
+			combinator = ' '
+		default:
+			return nil, p.error()
+		}
+	}
+}
+
+func (part *SelectorPart) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || (len(part.tag) > 0 && part.tag != n.Data) {
+		return false
+	}
+	if len(part.id) > 0 && getAttr(n, "id") != part.id {
+		return false
+	}
+	classes := strings.Fields(getAttr(n, "class"))
+	for _, class := range part.classes {
+		if !slices.Contains(classes, class) {
+			return false
+		}
+	}
+	for _, attr := range part.attrs {
+		value, ok := lookupAttr(n, attr.name)
+		if !ok {
+			return false
+		}
+		switch attr.op {
+		case "=":
+			ok = value == attr.value
+		case "~=":
+			ok = slices.Contains(strings.Fields(value), attr.value)
+		case "^=":
+			ok = len(attr.value) > 0 && strings.HasPrefix(value, attr.value)
+		case "$=":
+			ok = len(attr.value) > 0 && strings.HasSuffix(value, attr.value)
+		case "*=":
+			ok = len(attr.value) > 0 && strings.Contains(value, attr.value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchComplex(parts []SelectorPart, n *html.Node) bool {
+	last := len(parts) - 1
+	if !parts[last].matches(n) {
+		return false
+	}
+	if last == 0 {
+		return true
+	}
+	if parts[last].combinator == '>' {
+		return n.Parent != nil && matchComplex(parts[:last], n.Parent)
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if matchComplex(parts[:last], p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Selector) Match(n *html.Node) bool {
+	for _, parts := range s {
+		if matchComplex(parts, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryAll returns every element under n matching the selector, in document order.
+func (s Selector) QueryAll(n *html.Node) []*html.Node {
+	var nodes []*html.Node
+	for c := range n.Descendants() {
+		if s.Match(c) {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+func lookupAttr(n *html.Node, name string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func getAttr(n *html.Node, name string) string {
+	value, _ := lookupAttr(n, name)
+	return value
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Lengths, in characters, search engines show without truncating.
+var (
+	DEFAULT_TITLE_LENGTH       = LengthRange{min: 10, max: 60}
+	DEFAULT_DESCRIPTION_LENGTH = LengthRange{min: 50, max: 160}
+)
+
+type LengthRange struct {
+	min int
+	max int
+}
+
+// parseLengthRange parses a range written as MIN-MAX, for example "10-60".
+func parseLengthRange(raw string) (LengthRange, error) {
+	minimum, maximum, ok := strings.Cut(strings.TrimSpace(raw), "-")
+	if !ok {
+		return LengthRange{}, errors.New(fmt.Sprintf("%s %q", INVALID_LENGTH, raw))
+	}
+	low, err := strconv.Atoi(strings.TrimSpace(minimum))
+	if err != nil || low < 0 {
+		return LengthRange{}, errors.New(fmt.Sprintf("%s %q", INVALID_LENGTH, raw))
+	}
+	high, err := strconv.Atoi(strings.TrimSpace(maximum))
+	if err != nil || high < low || high == 0 {
+		return LengthRange{}, errors.New(fmt.Sprintf("%s %q", INVALID_LENGTH, raw))
+	}
+	return LengthRange{min: low, max: high}, nil
+}
+
+// orDefault returns r, or fallback when no range was configured.
+func (r LengthRange) orDefault(fallback LengthRange) LengthRange {
+	if r.max == 0 {
+		return fallback
+	}
+	return r
+}
+
+/*
+ * Metadata of a crawled page that the site wide checks
+ * need once the crawl is done.
+ */
+type SeoMetadata struct {
+	title       string
+	description string
+	canonical   string
+	noindex     bool
+}
+
+// metaContent returns the content of the first <meta name=NAME>.
+func metaContent(root *html.Node, name string) (string, bool) {
+	for n := range root.Descendants() {
+		if n.Type == html.ElementNode && n.Data == "meta" && strings.EqualFold(getAttr(n, "name"), name) {
+			return getAttr(n, "content"), true
+		}
+	}
+	return "", false
+}
+
+// hasNoindex reports whether a robots meta tag or X-Robots-Tag header forbids indexing.
+func hasNoindex(root *html.Node, header http.Header) bool {
+	directives := header.Values("X-Robots-Tag")
+	for n := range root.Descendants() {
+		if n.Type == html.ElementNode && n.Data == "meta" && strings.EqualFold(getAttr(n, "name"), "robots") {
+			directives = append(directives, getAttr(n, "content"))
+		}
+	}
+	for _, directive := range directives {
+		for _, value := range strings.Split(strings.ToLower(directive), ",") {
+			value = strings.TrimSpace(value)
+			if value == "noindex" || value == "none" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Context) checkSeo(link Link, root *html.Node, pageUrl *url.URL, header http.Header) (SeoMetadata, []LinkFailure) {
+	/*
+	 * Checks of a single page. Duplicates across the site
+	 * and the sitemap are checked by checkSeoPages once
+	 * every page has been crawled.
+	 */
+	var failures []LinkFailure
+	report := func(format string, args ...any) {
+		failures = append(failures, LinkFailure{link: link, check: "seo", err: errors.New(fmt.Sprintf(format, args...))})
+	}
+	checkLength := func(name string, value string, limits LengthRange) {
+		if length := utf8.RuneCountInString(value); length < limits.min || length > limits.max {
+			report("the %s is %d characters long, expected %d to %d", name, length, limits.min, limits.max)
+		}
+	}
+
+	metadata := SeoMetadata{noindex: hasNoindex(root, header)}
+	h1 := 0
+	var canonicals []*html.Node
+	title := false
+	for n := range root.Descendants() {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		if n.Data == "title" && !title {
+			title = true
+			metadata.title = nodeText(n)
+		} else if n.Data == "h1" {
+			h1++
+		} else if n.Data == "link" && strings.Contains(" "+strings.ToLower(getAttr(n, "rel"))+" ", " canonical ") {
+			canonicals = append(canonicals, n)
+		}
+	}
+
+	if len(metadata.title) == 0 {
+		report("the page has no <title>")
+	} else {
+		checkLength("title", metadata.title, c.titleLength.orDefault(DEFAULT_TITLE_LENGTH))
+	}
+	if description, ok := metaContent(root, "description"); !ok || len(strings.TrimSpace(description)) == 0 {
+		report("the page has no meta description")
+	} else {
+		metadata.description = strings.Join(strings.Fields(description), " ")
+		checkLength("description", metadata.description, c.descriptionLength.orDefault(DEFAULT_DESCRIPTION_LENGTH))
+	}
+	if h1 != 1 {
+		report("the page has %d <h1> elements, expected 1", h1)
+	}
+
+	/*
+	 * Like sitemaps, canonical links usually name the
+	 * production host, so only their path is compared.
+	 */
+	if len(canonicals) == 0 {
+		report("the page has no canonical link")
+	} else if len(canonicals) > 1 {
+		report("the page has %d canonical links, expected 1", len(canonicals))
+	} else {
+		href := strings.TrimSpace(getAttr(canonicals[0], "href"))
+		ref, err := url.Parse(href)
+		if err != nil || len(href) == 0 {
+			report("invalid canonical link %q", href)
+		} else if resolved := getBaseUrl(root, pageUrl).ResolveReference(ref); resolved.Scheme != "http" && resolved.Scheme != "https" {
+			report("invalid canonical link %q", href)
+		} else if len(resolved.Fragment) > 0 {
+			report("the canonical link %q has a fragment", href)
+		} else {
+			metadata.canonical = resolved.RequestURI()
+		}
+	}
+	return metadata, failures
+}
+
+func (c *Context) checkSeoPages(host string) error {
+	/*
+	 * Site wide checks. Pages that are not indexed, or
+	 * whose canonical link names another page, are
+	 * expected to share their title and description.
+	 */
+	var failures []LinkFailure
+	report := func(page PageResult, format string, args ...any) {
+		failures = append(failures, LinkFailure{link: page.link, check: "seo", err: errors.New(fmt.Sprintf(format, args...))})
+	}
+
+	pages := make(map[string]PageResult)
+	for _, page := range c.pages {
+		if _, ok := pages[page.link.path]; !ok {
+			pages[page.link.path] = page
+		}
+	}
+
+	/*
+	 * Canonical pages that were not crawled, because they
+	 * are excluded or only linked through the canonical
+	 * link, are requested without being crawled.
+	 */
+	targets := []Link{}
+	requested := make(map[string]bool)
+	for _, page := range c.pages {
+		if page.seo == nil || len(page.seo.canonical) == 0 {
+			continue
+		}
+		if _, ok := pages[page.seo.canonical]; !ok {
+			target := Link{path: page.seo.canonical, referrer: page.link.path}
+			pages[target.path] = PageResult{link: target}
+			requested[target.path] = true
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) > 0 {
+		for _, result := range crawlLevel(host, targets, 0, c) {
+			pages[result.link.path] = result
+		}
+	}
+
+	titles := make(map[string]string)
+	descriptions := make(map[string]string)
+	for _, page := range c.pages {
+		if page.seo == nil {
+			continue
+		}
+		if page.seo.noindex {
+			if sitemap, ok := c.sitemapPages[page.link.path]; ok {
+				report(page, "the page is noindex but listed in %s", sitemap)
+			}
+			continue
+		}
+		canonical := page.seo.canonical
+		if len(canonical) > 0 && canonical != page.link.path {
+			target := pages[canonical]
+			broken := target.err != nil || target.status != http.StatusOK
+			// Broken links found by the crawl are already reported.
+			if broken && (target.err == nil || requested[canonical]) {
+				report(page, "the canonical link points to %s which answers with %d", canonical, target.status)
+			} else if !broken && target.seo != nil && target.seo.noindex {
+				report(page, "the canonical link points to %s which is noindex", canonical)
+			}
+			continue
+		}
+		if first, ok := titles[page.seo.title]; ok && len(page.seo.title) > 0 {
+			report(page, "the title %q is also used by %s", page.seo.title, first)
+		} else {
+			titles[page.seo.title] = page.link.path
+		}
+		if first, ok := descriptions[page.seo.description]; ok && len(page.seo.description) > 0 {
+			report(page, "the description is also used by %s", first)
+		} else {
+			descriptions[page.seo.description] = page.link.path
+		}
+	}
+
+	for _, failure := range failures {
+		c.printv(os.Stderr, fmt.Sprintf("SEO problem on %s", failure.link.path), failure.err.Error())
+		c.failures = append(c.failures, failure)
+		if c.failFast {
+			return failure.err
+		}
+	}
+	return nil
+}
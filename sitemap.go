@@ -0,0 +1,179 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const DEFAULT_SITEMAP = "/sitemap.xml"
+
+// A <urlset> or a <sitemapindex>, see https://www.sitemaps.org/protocol.html
+type SitemapDocument struct {
+	XMLName  xml.Name     `xml:""`
+	Urls     []SitemapLoc `xml:"url"`
+	Sitemaps []SitemapLoc `xml:"sitemap"`
+}
+
+type SitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemapPath maps a sitemap URL onto the path to request from the crawled host.
+func sitemapPath(loc string) (string, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(loc))
+	if err != nil || !parsed.IsAbs() {
+		return "", false
+	}
+	return parsed.RequestURI(), true
+}
+
+// robotsSitemaps returns the Sitemap lines of a robots.txt file.
+func robotsSitemaps(content string) []string {
+	var sitemaps []string
+	for _, line := range strings.Split(content, "\n") {
+		key, value, ok := parseRobotsLine(line)
+		if ok && key == "sitemap" && len(value) > 0 {
+			sitemaps = append(sitemaps, value)
+		}
+	}
+	return sitemaps
+}
+
+func (c *Context) sitemapProblem(path string, err error) {
+	if !c.sitemap {
+		// Sitemaps read only for testSEO are optional.
+		c.printv(os.Stdout, fmt.Sprintf("Skipped sitemap %s", path), err.Error())
+		return
+	}
+	c.printv(os.Stderr, fmt.Sprintf("Broken sitemap %s", path), err.Error())
+	failure := LinkFailure{link: Link{path: path}, check: "sitemap", err: err}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		failure.status = statusErr.status
+	}
+	c.failures = append(c.failures, failure)
+}
+
+func fetchSitemap(host string, path string, ctx *Context) (SitemapDocument, error) {
+	var document SitemapDocument
+	res, err := Page(host, path, ctx)
+	if err != nil {
+		return document, err
+	}
+	defer res.Body.Close()
+
+	var body io.Reader = res.Body
+	if strings.HasSuffix(strings.SplitN(path, "?", 2)[0], ".gz") {
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return document, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	if err := xml.NewDecoder(body).Decode(&document); err != nil {
+		return document, err
+	}
+	if document.XMLName.Local != "urlset" && document.XMLName.Local != "sitemapindex" {
+		return document, errors.New(fmt.Sprintf("%s <%s>", INVALID_SITEMAP, document.XMLName.Local))
+	}
+	return document, nil
+}
+
+func (c *Context) readSitemaps(host string) []Link {
+	/*
+	 * Collect the pages listed in the sitemaps named by
+	 * robots.txt, or in /sitemap.xml when it names none.
+	 * Sitemap indexes are followed. Like in robots.txt,
+	 * the host of a sitemap URL is ignored: every URL is
+	 * requested from the host being tested.
+	 *
+	 * The returned links seed the crawl. Each of them is
+	 * "linked from" the sitemap that lists it, so broken
+	 * entries are reported like any other broken link.
+	 */
+	c.sitemapPages = make(map[string]string)
+	queue := []string{DEFAULT_SITEMAP}
+	if content, err := fetchRobots(host, c); err == nil {
+		if sitemaps := robotsSitemaps(content); len(sitemaps) > 0 {
+			queue = []string{}
+			for _, sitemap := range sitemaps {
+				if path, ok := sitemapPath(sitemap); ok {
+					queue = append(queue, path)
+				}
+			}
+		}
+	}
+
+	var links []Link
+	fetched := make(map[string]bool)
+	for len(queue) > 0 {
+		sitemap := queue[0]
+		queue = queue[1:]
+		if fetched[sitemap] {
+			continue
+		}
+		fetched[sitemap] = true
+
+		document, err := fetchSitemap(host, sitemap, c)
+		if err != nil {
+			c.sitemapProblem(sitemap, err)
+			continue
+		}
+		c.printv(os.Stdout, "Read sitemap", fmt.Sprintf("Read %d URLs and %d sitemaps from %s", len(document.Urls), len(document.Sitemaps), sitemap))
+		for _, nested := range document.Sitemaps {
+			if path, ok := sitemapPath(nested.Loc); ok {
+				queue = append(queue, path)
+			} else {
+				c.sitemapProblem(sitemap, errors.New(fmt.Sprintf("%s %q", INVALID_SITEMAP_URL, nested.Loc)))
+			}
+		}
+		for _, entry := range document.Urls {
+			path, ok := sitemapPath(entry.Loc)
+			if !ok {
+				c.sitemapProblem(sitemap, errors.New(fmt.Sprintf("%s %q", INVALID_SITEMAP_URL, entry.Loc)))
+				continue
+			}
+			if _, ok := c.sitemapPages[path]; ok {
+				continue
+			}
+			c.sitemapPages[path] = sitemap
+			links = append(links, Link{path: path, referrer: sitemap})
+		}
+	}
+	return links
+}
+
+func (c *Context) checkSitemapOrphans() {
+	/*
+	 * Every HTML page the crawl reached should be listed
+	 * in the sitemap, otherwise search engines may only
+	 * find it by chance. Pages are looked up by the path
+	 * they were served at, after any redirects, since that
+	 * is the one the sitemap should list.
+	 */
+	reported := make(map[string]bool)
+	for _, page := range c.pages {
+		if page.err != nil || page.status != http.StatusOK || page.link.asset || !isHtml(page.contentType) {
+			continue
+		}
+		final := page.link.path
+		if parsed, err := url.Parse(page.url); err == nil && len(page.url) > 0 {
+			final = parsed.RequestURI()
+		}
+		if _, ok := c.sitemapPages[final]; ok || reported[final] {
+			continue
+		}
+		reported[final] = true
+		failure := LinkFailure{link: page.link, check: "sitemap", err: errors.New(fmt.Sprintf("%s is not listed in the sitemap", final))}
+		c.printv(os.Stderr, fmt.Sprintf("%s is missing from the sitemap", final), "")
+		c.failures = append(c.failures, failure)
+	}
+}